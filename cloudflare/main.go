@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 
@@ -13,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
 )
 
 func post(filePath string, objectKey string, s3Client *s3.Client, bucketName string) {
@@ -25,7 +25,7 @@ func post(filePath string, objectKey string, s3Client *s3.Client, bucketName str
 	// fileStat, _ := file.Stat()
 	buffer := make([]byte, 512) // First 512 bytes are enough to detect the content type
 	file.Read(buffer)
-	contentType := http.DetectContentType(buffer)
+	contentType := imaging.DetectContentType(buffer)
 
 	// Reset the file pointer to the beginning
 	file.Seek(0, io.SeekStart)
@@ -61,12 +61,12 @@ func GetImage(fileName string, s3Client *s3.Client, bucketName string) (string,
 	}
 
 	contentType := *output.ContentType
-	if !isImageContentType(contentType) {
+	if !imaging.IsImageContentType(contentType) {
 		return "", fmt.Errorf("invalid file type: %s, expected image", contentType)
 	}
 
 	// Get file extension from content type
-	ext := getExtensionFromContentType(contentType)
+	ext := imaging.ExtensionForContentType(contentType)
 	if ext == "" {
 		return "", fmt.Errorf("unable to determine file extension for content type: %s", contentType)
 	}
@@ -93,28 +93,6 @@ func GetImage(fileName string, s3Client *s3.Client, bucketName string) (string,
 	return localFilePath, nil
 }
 
-func isImageContentType(contentType string) bool {
-	validImageTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/png":  true,
-		"image/gif":  true,
-		"image/webp": true,
-		"image/bmp":  true,
-	}
-	return validImageTypes[contentType]
-}
-
-func getExtensionFromContentType(contentType string) string {
-	contentTypeToExt := map[string]string{
-		"image/jpeg": ".jpg",
-		"image/png":  ".png",
-		"image/gif":  ".gif",
-		"image/webp": ".webp",
-		"image/bmp":  ".bmp",
-	}
-	return contentTypeToExt[contentType]
-}
-
 func getAllFileNames(s3Client *s3.Client, bucketName string) {
 	var continuationToken *string
 
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+	"github.com/imkishore16/go-cloudStorage/internal/service"
+)
+
+// ImportArchive uploads the images in the zip, tar or gzipped tar sent as
+// the archive form field under prefix, answering with a report on every
+// entry. Existing images are only replaced when overwrite is true
+func (h *ImageHandler) ImportArchive(c *gin.Context) {
+	overwrite, err := strconv.ParseBool(c.DefaultPostForm("overwrite", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "overwrite must be true or false"})
+		return
+	}
+
+	file, err := c.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read archive"})
+		return
+	}
+	archive, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read archive"})
+		return
+	}
+	defer archive.Close()
+
+	report, err := h.imageService.ImportArchive(c.Request.Context(), archive, file.Size, c.PostForm("prefix"), service.UploadOptions{
+		Overwrite: overwrite,
+	})
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
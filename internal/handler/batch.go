@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+	"github.com/imkishore16/go-cloudStorage/internal/service"
+)
+
+// RunBatch runs a list of delete, copy, move and tag operations and
+// answers with the outcome of each. With atomic set, a batch where any
+// operation fails undoes the operations that had completed
+func (h *ImageHandler) RunBatch(c *gin.Context) {
+	var req struct {
+		Operations  []service.BatchOperation `json:"operations"`
+		Atomic      bool                     `json:"atomic"`
+		Concurrency int                      `json:"concurrency"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	result, err := h.imageService.RunBatch(c.Request.Context(), service.BatchRequest{
+		Operations:  req.Operations,
+		Atomic:      req.Atomic,
+		Concurrency: req.Concurrency,
+	})
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
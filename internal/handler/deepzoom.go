@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// tilePattern matches the file name of a Deep Zoom tile, <column>_<row>.<ext>
+var tilePattern = regexp.MustCompile(`^[0-9]+_[0-9]+\.[a-z]+$`)
+
+// GetDeepZoom serves the .dzi descriptor of an image's tile pyramid.
+// Viewers find the tiles next to it under tiles_files/
+func (h *ImageHandler) GetDeepZoom(c *gin.Context) {
+	objectKey := c.Param("id")
+
+	descriptor, _, err := h.imageService.GetDeepZoom(c.Request.Context(), objectKey, "")
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Data(http.StatusOK, "application/xml", descriptor)
+}
+
+// GetDeepZoomTile serves a single tile of an image's pyramid
+func (h *ImageHandler) GetDeepZoomTile(c *gin.Context) {
+	objectKey := c.Param("id")
+
+	level, tile := c.Param("level"), c.Param("tile")
+	if _, err := strconv.Atoi(level); err != nil || !tilePattern.MatchString(tile) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tiles are addressed as <level>/<column>_<row>.<ext>"})
+		return
+	}
+
+	data, contentType, err := h.imageService.GetDeepZoom(c.Request.Context(), objectKey, level+"/"+tile)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Data(http.StatusOK, contentType, data)
+}
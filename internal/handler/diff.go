@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+	"github.com/imkishore16/go-cloudStorage/internal/service"
+)
+
+// DiffImages compares two images, or two versions of one, and answers with
+// the statistics of the comparison and a PNG highlighting what changed as a
+// data URI. With ?output=image the bare PNG is sent instead
+func (h *ImageHandler) DiffImages(c *gin.Context) {
+	var req struct {
+		Base           string `json:"base" binding:"required"`
+		BaseVersion    string `json:"baseVersion"`
+		Compare        string `json:"compare"`
+		CompareVersion string `json:"compareVersion"`
+		Tolerance      int    `json:"tolerance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	diffImage, diff, err := h.imageService.DiffImages(c.Request.Context(), service.DiffRequest{
+		Base:           req.Base,
+		BaseVersion:    req.BaseVersion,
+		Compare:        req.Compare,
+		CompareVersion: req.CompareVersion,
+		Tolerance:      req.Tolerance,
+	})
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("output") == "image" {
+		c.Data(http.StatusOK, "image/png", diffImage)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"diff":  diff,
+		"image": "data:image/png;base64," + base64.StdEncoding.EncodeToString(diffImage),
+	})
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+	"github.com/imkishore16/go-cloudStorage/internal/service"
+)
+
+type FileHandler struct {
+	fileService service.FileService
+}
+
+// FileHandlerConfig holds the dependencies of a FileHandler
+type FileHandlerConfig struct {
+	FileService service.FileService
+}
+
+func NewFileHandler(c *FileHandlerConfig) *FileHandler {
+	return &FileHandler{
+		fileService: c.FileService,
+	}
+}
+
+// GetFile serves a stored file as a download. Files are never rendered
+// inline, since they may hold HTML or script uploaded under another type
+func (h *FileHandler) GetFile(c *gin.Context) {
+	key := c.Param("id")
+
+	data, info, err := h.fileService.GetFile(c.Request.Context(), key)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
+	c.Header("Content-Security-Policy", "sandbox")
+	c.Header("X-Content-Type-Options", "nosniff")
+	if info.ETag != "" {
+		c.Header("ETag", info.ETag)
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (h *FileHandler) GetFileInfo(c *gin.Context) {
+	key := c.Param("id")
+
+	info, err := h.fileService.GetFileInfo(c.Request.Context(), key)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// PostFile stores the multipart file under the key form field, subject to
+// the policy for that key. Without a key the server names the file, and an
+// existing file is only replaced when overwrite is true
+func (h *FileHandler) PostFile(c *gin.Context) {
+	key := c.PostForm("key")
+	overwrite, err := strconv.ParseBool(c.DefaultPostForm("overwrite", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "overwrite must be true or false"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	body, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer body.Close()
+
+	info, err := h.fileService.PutFile(c.Request.Context(), key, service.FileUpload{
+		FileName:    file.Filename,
+		ContentType: file.Header.Get("Content-Type"),
+		Size:        file.Size,
+		Body:        body,
+		Tenant:      c.GetHeader(tenantHeader),
+		Overwrite:   overwrite,
+	})
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": "Failed to upload file: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "File uploaded successfully",
+		"file":    info,
+	})
+}
+
+func (h *FileHandler) DeleteFile(c *gin.Context) {
+	key := c.Param("id")
+
+	if err := h.fileService.DeleteFile(c.Request.Context(), key); err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "File deleted successfully",
+	})
+}
+
+func (h *FileHandler) ListFiles(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	list, err := h.fileService.ListFiles(c.Request.Context(), c.Query("prefix"), c.Query("cursor"), limit)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// ExportArchive streams a zip or tar.gz of the listed keys, or of every
+// file under prefix, with a manifest.json of their sizes and checksums
+func (h *FileHandler) ExportArchive(c *gin.Context) {
+	var req struct {
+		Keys   []string `json:"keys"`
+		Prefix string   `json:"prefix"`
+		Format string   `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+	format := service.ArchiveFormat(req.Format)
+	if format == "" {
+		format = service.ArchiveZip
+	}
+
+	name := strings.Trim(path.Base("/"+strings.TrimSuffix(req.Prefix, "/")), "/")
+	if name == "" {
+		name = "files"
+	}
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + string(format)}))
+
+	err := h.fileService.ExportArchive(c.Request.Context(), service.ExportRequest{
+		Keys:   req.Keys,
+		Prefix: req.Prefix,
+		Format: format,
+	}, c.Writer)
+	if err == nil {
+		return
+	}
+	if !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	// the status is long gone, so all that's left is to cut the archive short
+	log.Printf("Archive download failed part way: %v\n", err)
+	c.Abort()
+}
@@ -0,0 +1,360 @@
+package handler
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+const (
+	// IdempotencyHeader carries the client's key for a mutation
+	IdempotencyHeader = "Idempotency-Key"
+	// maxIdempotencyKey bounds the keys clients may send
+	maxIdempotencyKey = 255
+	// maxRecordedBody is the largest response kept for replay. Larger
+	// responses, such as rendered images, aren't recorded and a retry
+	// runs the request again
+	maxRecordedBody = 1 << 20
+)
+
+// idempotencyRecord is the outcome of the first request made with a key,
+// or a placeholder while that request is still running
+type idempotencyRecord struct {
+	fingerprint string
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// Idempotency replays the outcome of POST, PUT and DELETE requests
+// retried with the same Idempotency-Key, so that a retry doesn't
+// store or delete anything twice
+type Idempotency struct {
+	window     time.Duration
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	records map[string]*list.Element
+	// recent orders the records, most recently used first
+	recent    *list.List
+	bytes     int64
+	lastSweep time.Time
+}
+
+// IdempotencyConfig holds the settings of the Idempotency middleware.
+// Window is how long an outcome is replayed for. Records are kept in
+// memory, so retries have to reach the same instance. MaxEntries and
+// MaxBytes bound how many records, and how many bytes of recorded
+// responses, are kept before the least recently used are forgotten.
+// Zero disables either limit
+type IdempotencyConfig struct {
+	Window     time.Duration
+	MaxEntries int
+	MaxBytes   int64
+}
+
+func NewIdempotency(c *IdempotencyConfig) *Idempotency {
+	return &Idempotency{
+		window:     c.Window,
+		maxEntries: c.MaxEntries,
+		maxBytes:   c.MaxBytes,
+		records:    map[string]*list.Element{},
+		recent:     list.New(),
+	}
+}
+
+// idempotencyEntry is a record in the LRU list, with the key to find it by
+type idempotencyEntry struct {
+	key    string
+	record *idempotencyRecord
+}
+
+// size is roughly what a record keeps in memory
+func (e *idempotencyEntry) size() int64 {
+	return int64(len(e.key) + len(e.record.fingerprint) + len(e.record.contentType) + len(e.record.body))
+}
+
+// Handle is the middleware. The first request with a key runs and, unless
+// it fails on the server's side, its status and body are recorded. Later
+// requests with the key get that outcome back if they match the first one,
+// by method, path and body, and a Conflict if they don't
+func (m *Idempotency) Handle(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		c.Next()
+		return
+	}
+	key := c.GetHeader(IdempotencyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKey {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is longer than 255 characters"})
+		return
+	}
+
+	// keys are scoped to the client, so one client can't
+	// have another's responses replayed to it
+	key = clientScope(c) + ":" + key
+
+	fingerprint := newFingerprint(c.Request)
+	record, fresh := m.reserve(key)
+	if !fresh {
+		if err := m.replay(c, record, fingerprint); err != nil {
+			c.AbortWithStatusJSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		}
+		return
+	}
+	// released unless completed, which covers handlers that panic
+	completed := false
+	defer func() {
+		if !completed {
+			m.release(key, record)
+		}
+	}()
+
+	// the body is hashed as the handler reads it, rather than held in memory
+	c.Request.Body = &hashingBody{ReadCloser: c.Request.Body, fingerprint: fingerprint}
+	recorder := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Next()
+
+	status := recorder.Status()
+	if status >= http.StatusInternalServerError || recorder.overflow {
+		return
+	}
+	m.complete(key, record, fingerprint.sum(c.Request.Body), status, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+	completed = true
+}
+
+// reserve returns the record for key, or makes a placeholder for it and
+// reports that the caller's request is the first. Placeholders expire
+// after the window too, so a request that never finishes doesn't hold
+// its key for good
+func (m *Idempotency) reserve(key string) (*idempotencyRecord, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for e := m.recent.Back(); e != nil; {
+			prev := e.Prev()
+			if e.Value.(*idempotencyEntry).record.expired(now) {
+				m.remove(e)
+			}
+			e = prev
+		}
+		m.lastSweep = now
+	}
+
+	if e, ok := m.records[key]; ok {
+		if r := e.Value.(*idempotencyEntry).record; !r.expired(now) {
+			m.recent.MoveToFront(e)
+			// copied, since the first request may still fill it in
+			copied := *r
+			return &copied, false
+		}
+		m.remove(e)
+	}
+	placeholder := &idempotencyRecord{expires: now.Add(m.window)}
+	m.add(&idempotencyEntry{key: key, record: placeholder})
+	return placeholder, true
+}
+
+// expired reports whether a record, or placeholder, is past its window
+func (r *idempotencyRecord) expired(now time.Time) bool {
+	return now.After(r.expires)
+}
+
+// complete replaces a request's placeholder with its outcome, unless the
+// placeholder expired and the key was taken by a retry meanwhile
+func (m *Idempotency) complete(key string, placeholder *idempotencyRecord, fingerprint string, status int, contentType string, body []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.records[key]; ok {
+		if e.Value.(*idempotencyEntry).record != placeholder {
+			return
+		}
+		m.remove(e)
+	}
+	m.add(&idempotencyEntry{key: key, record: &idempotencyRecord{
+		fingerprint: fingerprint,
+		done:        true,
+		status:      status,
+		contentType: contentType,
+		body:        body,
+		expires:     time.Now().Add(m.window),
+	}})
+}
+
+// release forgets a key whose request didn't get an outcome worth
+// replaying, so that a retry runs it again
+func (m *Idempotency) release(key string, placeholder *idempotencyRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.records[key]; ok && e.Value.(*idempotencyEntry).record == placeholder {
+		m.remove(e)
+	}
+}
+
+// add records an entry as the most recently used, then forgets the least
+// recently used finished records until the limits are met. Placeholders
+// of requests still running are kept, or a retry could run alongside
+// them, but expired ones are forgotten as finished records are
+func (m *Idempotency) add(entry *idempotencyEntry) {
+	m.records[entry.key] = m.recent.PushFront(entry)
+	m.bytes += entry.size()
+
+	now := time.Now()
+	for e := m.recent.Back(); e != nil && m.overLimits(); {
+		prev := e.Prev()
+		if r := e.Value.(*idempotencyEntry).record; r.done || r.expired(now) {
+			m.remove(e)
+		}
+		e = prev
+	}
+}
+
+func (m *Idempotency) overLimits() bool {
+	return (m.maxEntries > 0 && len(m.records) > m.maxEntries) || (m.maxBytes > 0 && m.bytes > m.maxBytes)
+}
+
+func (m *Idempotency) remove(e *list.Element) {
+	entry := m.recent.Remove(e).(*idempotencyEntry)
+	delete(m.records, entry.key)
+	m.bytes -= entry.size()
+}
+
+// clientScope identifies who sent a request: a hash of its credentials
+// when it has any, or else its address
+func clientScope(c *gin.Context) string {
+	client := c.ClientIP()
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		client = auth
+	}
+	sum := sha256.Sum256([]byte(client))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (m *Idempotency) replay(c *gin.Context, record *idempotencyRecord, fingerprint *fingerprint) error {
+	if !record.done {
+		return apperrors.NewIdempotencyConflict("a request with this key is still in progress")
+	}
+	if fingerprint.sum(c.Request.Body) != record.fingerprint {
+		return apperrors.NewIdempotencyConflict("the key was already used for a different request")
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.status, record.contentType, record.body)
+	c.Abort()
+	return nil
+}
+
+// fingerprint identifies a request by its method, path and body.
+// Multipart boundaries are left out, since clients pick a new one
+// each time they build a request, retries included
+type fingerprint struct {
+	hash hash.Hash
+	w    io.Writer
+}
+
+func newFingerprint(r *http.Request) *fingerprint {
+	h := sha256.New()
+	f := &fingerprint{hash: h, w: h}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+" "+mediaType+"\n")
+	if boundary := params["boundary"]; strings.HasPrefix(mediaType, "multipart/") && boundary != "" {
+		f.w = &boundaryStripper{w: h, boundary: []byte(boundary)}
+	}
+	return f
+}
+
+// sum finishes the fingerprint with whatever of body is left unread
+func (f *fingerprint) sum(body io.Reader) string {
+	if hb, ok := body.(*hashingBody); ok {
+		body = hb.ReadCloser
+	}
+	if body != nil {
+		io.Copy(f.w, body)
+	}
+	if bs, ok := f.w.(*boundaryStripper); ok {
+		bs.flush()
+	}
+	return hex.EncodeToString(f.hash.Sum(nil))
+}
+
+// hashingBody feeds a request body to a fingerprint as it is read
+type hashingBody struct {
+	io.ReadCloser
+	fingerprint *fingerprint
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.fingerprint.w.Write(p[:n])
+	return n, err
+}
+
+// boundaryStripper writes through to w all but the occurrences of
+// boundary, holding back what could be the start of one
+type boundaryStripper struct {
+	w        io.Writer
+	boundary []byte
+	pending  []byte
+}
+
+func (s *boundaryStripper) Write(p []byte) (int, error) {
+	s.pending = bytes.ReplaceAll(append(s.pending, p...), s.boundary, nil)
+	if keep := len(s.boundary) - 1; len(s.pending) > keep {
+		s.w.Write(s.pending[:len(s.pending)-keep])
+		s.pending = append(s.pending[:0], s.pending[len(s.pending)-keep:]...)
+	}
+	return len(p), nil
+}
+
+func (s *boundaryStripper) flush() {
+	s.w.Write(s.pending)
+	s.pending = nil
+}
+
+// recordingWriter keeps a copy of the response body for replay
+type recordingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *recordingWriter) record(data []byte) {
+	if w.overflow || w.body.Len()+len(data) > maxRecordedBody {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+const (
+	iiifContext  = "http://iiif.io/api/image/3/context.json"
+	iiifProtocol = "http://iiif.io/api/image"
+	iiifProfile  = "http://iiif.io/api/image/3/level2.json"
+	// side of the tiles advertised to deep zoom viewers
+	iiifTileSize = 512
+	// smallest full image size advertised in info.json
+	iiifMinSize = 128
+)
+
+// errNotImplemented marks valid IIIF requests for features we don't support
+var errNotImplemented = errors.New("not implemented")
+
+// GetIIIF serves the IIIF Image API 3.0 for a stored image: its info.json,
+// and image requests of the form {region}/{size}/{rotation}/{quality}.{format}.
+// Identifiers containing slashes must have them escaped as %2F. The tiles and
+// sizes info.json advertises are open to viewers, while other requests render
+// a variant of the client's choosing and have to be signed when a signing
+// key is configured, as variant URLs do
+func (h *ImageHandler) GetIIIF(c *gin.Context) {
+	objectKey := c.Param("id")
+	c.Header("Access-Control-Allow-Origin", "*")
+
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	switch path {
+	case "":
+		c.Redirect(http.StatusSeeOther, h.iiifID(objectKey)+"/info.json")
+		return
+	case "info.json":
+		h.getIIIFInfo(c, objectKey)
+		return
+	}
+
+	segments := strings.Split(path, "/")
+	if len(segments) != 4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "IIIF image requests are {region}/{size}/{rotation}/{quality}.{format}"})
+		return
+	}
+
+	info, err := h.imageService.GetImageInfo(c.Request.Context(), objectKey)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	opts, err := iiifOptions(segments, info.Width, info.Height, h.imageService.Limits())
+	if errors.Is(err, errNotImplemented) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "only rotations by multiples of 90 degrees are supported"})
+		return
+	}
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !iiifAdvertised(opts, info.Width, info.Height) && !h.validSignature(iiifResource(objectKey, path), c.Request.URL.Query()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid signature"})
+		return
+	}
+
+	imageData, contentType, err := h.imageService.TransformImage(c.Request.Context(), objectKey, opts)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Link", fmt.Sprintf("<%s>;rel=\"profile\"", iiifProfile))
+	c.Data(http.StatusOK, contentType, imageData)
+}
+
+func (h *ImageHandler) getIIIFInfo(c *gin.Context, objectKey string) {
+	info, err := h.imageService.GetImageInfo(c.Request.Context(), objectKey)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	limits := h.imageService.Limits()
+
+	var sizes []gin.H
+	for _, size := range iiifSizes(info.Width, info.Height) {
+		sizes = append(sizes, gin.H{"width": size.X, "height": size.Y})
+	}
+
+	body := gin.H{
+		"@context": iiifContext,
+		"id":       h.iiifID(objectKey),
+		"type":     "ImageService3",
+		"protocol": iiifProtocol,
+		"profile":  "level2",
+		"width":    info.Width,
+		"height":   info.Height,
+		"tiles": []gin.H{{
+			"width":        iiifTileSize,
+			"scaleFactors": iiifScaleFactors(info.Width, info.Height),
+		}},
+		"sizes":          sizes,
+		"extraFormats":   []string{"webp", "gif", "tif", "bmp"},
+		"extraQualities": []string{"color", "gray", "bitonal"},
+		"extraFeatures": []string{
+			"cors", "mirroring", "profileLinkHeader", "regionByPct", "regionByPx",
+			"regionSquare", "rotationBy90s", "sizeByConfinedWh", "sizeByH", "sizeByPct",
+			"sizeByW", "sizeByWh", "sizeUpscaling",
+		},
+	}
+	if limits.MaxWidth > 0 {
+		body["maxWidth"] = limits.MaxWidth
+	}
+	if limits.MaxHeight > 0 {
+		body["maxHeight"] = limits.MaxHeight
+	}
+	if limits.MaxPixels > 0 {
+		body["maxArea"] = limits.MaxPixels
+	}
+
+	contentType := "application/json"
+	if strings.Contains(c.GetHeader("Accept"), "application/ld+json") {
+		contentType = fmt.Sprintf("application/ld+json;profile=%q", iiifContext)
+	}
+	c.Header("Content-Type", contentType)
+	c.JSON(http.StatusOK, body)
+}
+
+// iiifScaleFactors are the scale factors of the advertised tiles:
+// the image is halved until it fits a single tile
+func iiifScaleFactors(width, height int) []int {
+	scaleFactors := []int{1}
+	for sf := 2; max(width, height)/(sf/2) > iiifTileSize; sf *= 2 {
+		scaleFactors = append(scaleFactors, sf)
+	}
+	return scaleFactors
+}
+
+// iiifSizes are the advertised sizes of the full image, smallest
+// first as the spec asks
+func iiifSizes(width, height int) []image.Point {
+	var sizes []image.Point
+	for sw, sh := width, height; sw >= iiifMinSize && sh >= 1; sw, sh = sw/2, sh/2 {
+		sizes = append([]image.Point{{X: sw, Y: sh}}, sizes...)
+	}
+	return sizes
+}
+
+// iiifAdvertised reports whether a request is for one of the sizes of
+// the full image or one of the tiles that info.json advertises, upright
+// and in color. Tile sizes may be off by one, as viewers round them
+func iiifAdvertised(opts imaging.TransformOptions, width, height int) bool {
+	if opts.Mirror || opts.Rotate != 0 || opts.Quality != "" {
+		return false
+	}
+
+	region := opts.Crop
+	if region == image.Rect(0, 0, width, height) {
+		for _, size := range iiifSizes(width, height) {
+			if opts.Width == size.X && opts.Height == size.Y {
+				return true
+			}
+		}
+	}
+
+	for _, sf := range iiifScaleFactors(width, height) {
+		side := iiifTileSize * sf
+		x, y := region.Min.X, region.Min.Y
+		if x%side != 0 || y%side != 0 || region.Dx() != min(side, width-x) || region.Dy() != min(side, height-y) {
+			continue
+		}
+		tw, th := (region.Dx()+sf-1)/sf, (region.Dy()+sf-1)/sf
+		if abs(opts.Width-tw) <= 1 && abs(opts.Height-th) <= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// iiifID is the base URI of an image's IIIF service
+func (h *ImageHandler) iiifID(objectKey string) string {
+	return h.baseURL + "/iiif/" + url.PathEscape(objectKey)
+}
+
+// iiifOptions turns the path segments of an IIIF image request for a
+// width x height image into the transform that renders it
+func iiifOptions(segments []string, width, height int, limits imaging.Limits) (imaging.TransformOptions, error) {
+	var opts imaging.TransformOptions
+
+	region, err := iiifRegion(segments[0], width, height)
+	if err != nil {
+		return opts, err
+	}
+	w, h, err := iiifSize(segments[1], region.Dx(), region.Dy(), limits)
+	if err != nil {
+		return opts, err
+	}
+	opts.Crop, opts.Width, opts.Height, opts.Fit = region, w, h, imaging.FitFill
+
+	rotation := segments[2]
+	if strings.HasPrefix(rotation, "!") {
+		opts.Mirror = true
+		rotation = rotation[1:]
+	}
+	degrees, err := strconv.ParseFloat(rotation, 64)
+	if err != nil || degrees < 0 || degrees > 360 {
+		return opts, apperrors.NewBadRequest(fmt.Sprintf("invalid rotation %q", segments[2]))
+	}
+	if math.Mod(degrees, 90) != 0 {
+		return opts, errNotImplemented
+	}
+	opts.Rotate = int(degrees)
+
+	quality, format, ok := strings.Cut(segments[3], ".")
+	if !ok {
+		return opts, apperrors.NewBadRequest("missing format")
+	}
+	switch quality {
+	case "default", "color":
+	case "gray":
+		opts.Quality = imaging.QualityGray
+	case "bitonal":
+		opts.Quality = imaging.QualityBitonal
+	default:
+		return opts, apperrors.NewBadRequest(fmt.Sprintf("unknown quality %q", quality))
+	}
+	if opts.Format, err = formatParam(format); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// iiifRegion reads a region: full, square, x,y,w,h in pixels or
+// pct:x,y,w,h in percent of the image. Regions are clipped to the image
+func iiifRegion(v string, width, height int) (image.Rectangle, error) {
+	full := image.Rect(0, 0, width, height)
+	switch v {
+	case "full":
+		return full, nil
+	case "square":
+		side := min(width, height)
+		x, y := (width-side)/2, (height-side)/2
+		return image.Rect(x, y, x+side, y+side), nil
+	}
+
+	pct := strings.HasPrefix(v, "pct:")
+	parts := strings.Split(strings.TrimPrefix(v, "pct:"), ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, apperrors.NewBadRequest(fmt.Sprintf("invalid region %q", v))
+	}
+
+	var n [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f < 0 || (!pct && f != math.Trunc(f)) {
+			return image.Rectangle{}, apperrors.NewBadRequest(fmt.Sprintf("invalid region %q", v))
+		}
+		n[i] = f
+	}
+	if pct {
+		n[0], n[2] = n[0]*float64(width)/100, n[2]*float64(width)/100
+		n[1], n[3] = n[1]*float64(height)/100, n[3]*float64(height)/100
+	}
+
+	x, y := int(math.Round(n[0])), int(math.Round(n[1]))
+	region := image.Rect(x, y, x+int(math.Round(n[2])), y+int(math.Round(n[3]))).Intersect(full)
+	if region.Empty() {
+		return image.Rectangle{}, apperrors.NewBadRequest(fmt.Sprintf("region %q lies outside the image", v))
+	}
+	return region, nil
+}
+
+// iiifSize reads the size of a region rw x rh is scaled to: max, w,
+// ,h, pct:n, w,h or !w,h, each with an optional ^ to allow upscaling
+func iiifSize(v string, rw, rh int, limits imaging.Limits) (int, int, error) {
+	upscale := strings.HasPrefix(v, "^")
+	spec := strings.TrimPrefix(v, "^")
+	invalid := apperrors.NewBadRequest(fmt.Sprintf("invalid size %q", v))
+
+	var w, h int
+	switch {
+	case spec == "max":
+		scale := maxScale(rw, rh, limits)
+		if !upscale {
+			scale = math.Min(scale, 1)
+		}
+		w, h = scaledSize(rw, scale), scaledSize(rh, scale)
+	case strings.HasPrefix(spec, "pct:"):
+		n, err := strconv.ParseFloat(spec[4:], 64)
+		if err != nil || n <= 0 {
+			return 0, 0, invalid
+		}
+		w, h = scaledSize(rw, n/100), scaledSize(rh, n/100)
+	default:
+		confined := strings.HasPrefix(spec, "!")
+		ws, hs, ok := strings.Cut(strings.TrimPrefix(spec, "!"), ",")
+		if !ok {
+			return 0, 0, invalid
+		}
+		var err error
+		if ws != "" {
+			if w, err = strconv.Atoi(ws); err != nil || w <= 0 {
+				return 0, 0, invalid
+			}
+		}
+		if hs != "" {
+			if h, err = strconv.Atoi(hs); err != nil || h <= 0 {
+				return 0, 0, invalid
+			}
+		}
+
+		switch {
+		case w == 0 && h == 0, confined && (w == 0 || h == 0):
+			return 0, 0, invalid
+		case confined:
+			scale := math.Min(float64(w)/float64(rw), float64(h)/float64(rh))
+			w, h = scaledSize(rw, scale), scaledSize(rh, scale)
+		case h == 0:
+			h = scaledSize(rh, float64(w)/float64(rw))
+		case w == 0:
+			w = scaledSize(rw, float64(h)/float64(rh))
+		}
+	}
+
+	if !upscale && (w > rw || h > rh) {
+		return 0, 0, apperrors.NewBadRequest(fmt.Sprintf("size %q is larger than the region, use ^ to upscale", v))
+	}
+	return w, h, nil
+}
+
+// maxScale is the largest scale of a w x h image within the limits
+func maxScale(w, h int, limits imaging.Limits) float64 {
+	scale := math.Inf(1)
+	if limits.MaxWidth > 0 {
+		scale = math.Min(scale, float64(limits.MaxWidth)/float64(w))
+	}
+	if limits.MaxHeight > 0 {
+		scale = math.Min(scale, float64(limits.MaxHeight)/float64(h))
+	}
+	if limits.MaxPixels > 0 {
+		scale = math.Min(scale, math.Sqrt(float64(limits.MaxPixels)/float64(w*h)))
+	}
+	if math.IsInf(scale, 1) {
+		return 1
+	}
+	return scale
+}
+
+func scaledSize(length int, scale float64) int {
+	return max(1, int(math.Round(float64(length)*scale)))
+}
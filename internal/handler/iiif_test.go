@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"image"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+func TestIIIFOptions(t *testing.T) {
+	const width, height = 1000, 800
+	limits := imaging.Limits{MaxWidth: 4000, MaxHeight: 4000}
+
+	tests := []struct {
+		name       string
+		path       string
+		crop       image.Rectangle
+		w, h       int
+		wantStatus int
+	}{
+		{name: "full", path: "full/max/0/default.jpg", crop: image.Rect(0, 0, 1000, 800), w: 1000, h: 800},
+		{name: "square", path: "square/max/0/default.jpg", crop: image.Rect(100, 0, 900, 800), w: 800, h: 800},
+		{name: "region clipped to the image", path: "900,700,500,500/max/0/default.jpg", crop: image.Rect(900, 700, 1000, 800), w: 100, h: 100},
+		{name: "percent region", path: "pct:50,50,50,50/250,/0/default.png", crop: image.Rect(500, 400, 1000, 800), w: 250, h: 200},
+		{name: "upscaled to the limits", path: "full/^max/0/default.jpg", crop: image.Rect(0, 0, 1000, 800), w: 4000, h: 3200},
+		{name: "confined", path: "full/!100,100/0/default.jpg", crop: image.Rect(0, 0, 1000, 800), w: 100, h: 80},
+
+		{name: "region right of the image", path: "1000,0,10,10/max/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "region below the image", path: "0,800,10,10/max/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "percent region outside the image", path: "pct:101,0,10,10/max/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "empty region", path: "0,0,0,0/max/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "negative region", path: "-10,0,100,100/max/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "fractional pixel region", path: "0.5,0,100,100/max/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "short region", path: "0,0,100/max/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "width beyond the region", path: "full/1001,/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "height beyond a clipped region", path: "900,700,500,500/,101/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "percent beyond the region", path: "full/pct:101/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "zero width", path: "full/0,/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "zero percent", path: "full/pct:0/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "no width or height", path: "full/,/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "confined without height", path: "full/!100,/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "negative size", path: "full/-100,/0/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "rotation beyond a turn", path: "full/max/450/default.jpg", wantStatus: http.StatusBadRequest},
+		{name: "arbitrary rotation", path: "full/max/45/default.jpg", wantStatus: http.StatusNotImplemented},
+		{name: "unknown quality", path: "full/max/0/sepia.jpg", wantStatus: http.StatusBadRequest},
+		{name: "missing format", path: "full/max/0/default", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := iiifOptions(strings.Split(tt.path, "/"), width, height, limits)
+			if tt.wantStatus != 0 {
+				status := apperrors.Status(err)
+				if errors.Is(err, errNotImplemented) {
+					status = http.StatusNotImplemented
+				}
+				if err == nil || status != tt.wantStatus {
+					t.Fatalf("iiifOptions(%q) error = %v, want status %d", tt.path, err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("iiifOptions(%q) error = %v", tt.path, err)
+			}
+			if opts.Crop != tt.crop || opts.Width != tt.w || opts.Height != tt.h {
+				t.Errorf("iiifOptions(%q) = %v %dx%d, want %v %dx%d", tt.path, opts.Crop, opts.Width, opts.Height, tt.crop, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestIIIFAdvertised(t *testing.T) {
+	const width, height = 1500, 1000
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{name: "full size", path: "full/max/0/default.jpg", want: true},
+		{name: "listed size", path: "full/375,/0/default.jpg", want: true},
+		{name: "first tile", path: "0,0,512,512/512,/0/default.jpg", want: true},
+		{name: "edge tile", path: "1024,512,476,488/476,488/0/default.jpg", want: true},
+		{name: "scaled tile", path: "0,0,1024,1000/512,/0/default.jpg", want: true},
+		{name: "whole image at the largest scale factor", path: "full/375,250/0/color.webp", want: true},
+		{name: "unlisted size", path: "full/400,/0/default.jpg", want: false},
+		{name: "tile off the grid", path: "1,0,512,512/512,/0/default.jpg", want: false},
+		{name: "tile at the wrong size", path: "0,0,512,512/256,/0/default.jpg", want: false},
+		{name: "rotated tile", path: "0,0,512,512/512,/90/default.jpg", want: false},
+		{name: "gray tile", path: "0,0,512,512/512,/0/gray.jpg", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := iiifOptions(strings.Split(tt.path, "/"), width, height, imaging.Limits{})
+			if err != nil {
+				t.Fatalf("iiifOptions(%q) error = %v", tt.path, err)
+			}
+			if got := iiifAdvertised(opts, width, height); got != tt.want {
+				t.Errorf("iiifAdvertised(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,43 +1,301 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
 	"github.com/imkishore16/go-cloudStorage/internal/service"
 )
 
+// tenantHeader names the tenant that key templates file uploads under
+const tenantHeader = "X-Tenant-ID"
+
 type ImageHandler struct {
 	imageService service.ImageService
+	baseURL      string
+	signingKey   []byte
+}
+
+// ImageHandlerConfig holds the dependencies of an ImageHandler. BaseURL
+// prefixes the variant URLs handed out, which are relative when it is
+// empty. When SigningKey is set, variant requests must carry a signature
+type ImageHandlerConfig struct {
+	ImageService service.ImageService
+	BaseURL      string
+	SigningKey   []byte
 }
 
-func NewImageHandler(imageService service.ImageService) *ImageHandler {
+func NewImageHandler(c *ImageHandlerConfig) *ImageHandler {
 	return &ImageHandler{
-		imageService: imageService,
+		imageService: c.ImageService,
+		baseURL:      strings.TrimSuffix(c.BaseURL, "/"),
+		signingKey:   c.SigningKey,
 	}
 }
 
+// GetImage serves the stored image, or a variant of it when
+// transformation parameters are given in the query string
 func (h *ImageHandler) GetImage(c *gin.Context) {
 	objectKey := c.Param("id")
 
-	localFilePath, contentType, err := h.imageService.GetImage(c.Request.Context(), objectKey)
+	// fmt=original forces the stored bytes, skipping negotiation
+	if c.Query("fmt") == "original" {
+		h.getOriginalImage(c, objectKey)
+		return
+	}
+
+	opts, err := transformOptions(c)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !opts.IsZero() || c.Query("wm") != "" {
+		if !h.validSignature(objectKey, c.Request.URL.Query()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid signature"})
+			return
+		}
+	}
+	if name := c.Query("wm"); name != "" {
+		if opts.Watermark, err = h.imageService.Watermark(name); err != nil {
+			c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// without an explicit format the response depends on the Accept header
+	var accepted []string
+	if opts.Format == "" {
+		c.Header("Vary", "Accept")
+		accepted = acceptedFormats(c.GetHeader("Accept"))
+	}
+
+	var imageData []byte
+	var contentType string
+	switch {
+	case len(accepted) > 0:
+		imageData, contentType, err = h.imageService.NegotiateImage(c.Request.Context(), objectKey, opts, accepted)
+	case opts.IsZero():
+		imageData, contentType, err = h.imageService.GetImage(c.Request.Context(), objectKey)
+	default:
+		imageData, contentType, err = h.imageService.TransformImage(c.Request.Context(), objectKey, opts)
+	}
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	writeImage(c, contentType, imageData)
+}
+
+func (h *ImageHandler) getOriginalImage(c *gin.Context, objectKey string) {
+	for _, param := range []string{"w", "h", "fit", "crop", "wm"} {
+		if c.Query(param) != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fmt=original can't be combined with " + param})
+			return
+		}
+	}
+
+	imageData, contentType, err := h.imageService.GetImage(c.Request.Context(), objectKey)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	writeImage(c, contentType, imageData)
+}
+
+// writeImage sends an image. SVGs are sent with a policy that keeps
+// them from running script when opened directly in a browser
+func writeImage(c *gin.Context, contentType string, data []byte) {
+	if contentType == imaging.SVGContentType {
+		c.Header("Content-Security-Policy", imaging.SVGCSP)
+		c.Header("X-Content-Type-Options", "nosniff")
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// GetImageFrame serves a single frame of an animated image as PNG,
+// or in the format given by fmt. The frame "poster" is frame 0
+func (h *ImageHandler) GetImageFrame(c *gin.Context) {
+	objectKey := c.Param("id")
+
+	index := 0
+	if frame := c.Param("frame"); frame != "poster" {
+		var err error
+		if index, err = strconv.Atoi(frame); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "frame must be an index or poster"})
+			return
+		}
+	}
+
+	format, err := formatParam(c.DefaultQuery("fmt", "png"))
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	frame, contentType, err := h.imageService.GetImageFrame(c.Request.Context(), objectKey, index, format)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, frame)
+}
+
+func (h *ImageHandler) GetImageInfo(c *gin.Context) {
+	objectKey := c.Param("id")
+
+	info, err := h.imageService.GetImageInfo(c.Request.Context(), objectKey)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// SetFocalPoint stores the point smart crops of an image keep in frame,
+// given as x and y fractions of its width and height from the top left
+func (h *ImageHandler) SetFocalPoint(c *gin.Context) {
+	objectKey := c.Param("id")
+
+	var focus struct {
+		X *float64 `json:"x"`
+		Y *float64 `json:"y"`
+	}
+	if err := c.ShouldBindJSON(&focus); err != nil || focus.X == nil || focus.Y == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "x and y are required"})
+		return
+	}
+
+	info, err := h.imageService.SetFocalPoint(c.Request.Context(), objectKey, &model.FocalPoint{X: *focus.X, Y: *focus.Y})
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// ClearFocalPoint removes an image's focal point, so smart crops
+// fall back to the most salient part of the image
+func (h *ImageHandler) ClearFocalPoint(c *gin.Context) {
+	objectKey := c.Param("id")
+
+	info, err := h.imageService.SetFocalPoint(c.Request.Context(), objectKey, nil)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+func (h *ImageHandler) OptimizeImages(c *gin.Context) {
+	var req struct {
+		Prefix           string  `json:"prefix"`
+		MaxQuantizeError float64 `json:"maxQuantizeError"`
+		JPEGQuality      int     `json:"jpegQuality"`
+		DryRun           bool    `json:"dryRun"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := imaging.OptimizeOptions{
+		MaxQuantizeError: req.MaxQuantizeError,
+		JPEGQuality:      req.JPEGQuality,
+	}
+	report, err := h.imageService.OptimizeImages(c.Request.Context(), req.Prefix, opts, req.DryRun)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *ImageHandler) ListImages(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	opts := service.ListOptions{
+		Prefix: c.Query("prefix"),
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+	}
+	if v := c.Query("color"); v != "" {
+		color, err := imaging.ParseColor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tolerance, err := strconv.ParseFloat(c.DefaultQuery("tolerance", "30"), 64)
+		if err != nil || tolerance < 0 || tolerance > 442 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance must be between 0 and 442"})
+			return
+		}
+		opts.Color = &color
+		opts.Tolerance = tolerance
+	}
+
+	list, err := h.imageService.ListImages(c.Request.Context(), opts)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// GetSimilarImages lists the stored images that look like the given one.
+// maxDistance is the largest Hamming distance between pHashes to match
+func (h *ImageHandler) GetSimilarImages(c *gin.Context) {
+	objectKey := c.Param("id")
+
+	maxDistance, err := strconv.Atoi(c.DefaultQuery("maxDistance", "10"))
+	if err != nil || maxDistance < 0 || maxDistance > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxDistance must be between 0 and 64"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	similar, err := h.imageService.FindSimilarImages(c.Request.Context(), objectKey, maxDistance, limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":       "Image retrieved successfully",
-		"localFilePath": localFilePath,
-		"contentType":   contentType,
+		"key":     objectKey,
+		"similar": similar,
 	})
 }
 
 func (h *ImageHandler) PostImage(c *gin.Context) {
+	// without an objectKey the server names the image, and an
+	// existing image is only replaced when overwrite is set
 	type ImageUploadRequest struct {
-		ObjectKey string `json:"objectKey" binding:"required"`
-		FilePath  string `json:"filePath" binding:"required"`
+		ObjectKey   string `json:"objectKey"`
+		FilePath    string `json:"filePath" binding:"required"`
+		ContentType string `json:"contentType"`
+		Overwrite   bool   `json:"overwrite"`
 	}
 
 	var req ImageUploadRequest
@@ -49,16 +307,24 @@ func (h *ImageHandler) PostImage(c *gin.Context) {
 	}
 
 	// Call the service to upload the file using the provided filePath and objectKey
-	url, err := h.imageService.PostImage(c.Request.Context(), req.FilePath, req.ObjectKey)
+	result, err := h.imageService.PostImage(c.Request.Context(), req.FilePath, req.ObjectKey, req.ContentType, service.UploadOptions{
+		Tenant:    c.GetHeader(tenantHeader),
+		Overwrite: req.Overwrite,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image: " + err.Error()})
+		uploadError(c, "Failed to upload image: ", err)
 		return
 	}
 
 	// Respond with the uploaded file URL
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Image uploaded successfully",
-		"url":     url,
+		"message":        "Image uploaded successfully",
+		"key":            result.Key,
+		"url":            result.URL,
+		"info":           result.Info,
+		"nearDuplicates": result.NearDuplicates,
+		"optimization":   result.Optimization,
+		"deepZoom":       result.DeepZoom,
 	})
 }
 
@@ -84,24 +350,42 @@ func (h *ImageHandler) UpdateImage(c *gin.Context) {
 		_ = os.Remove(tempFilePath)
 	}()
 
-	url, err := h.imageService.UpdateImage(c.Request.Context(), tempFilePath, objectKey)
+	result, err := h.imageService.UpdateImage(c.Request.Context(), tempFilePath, objectKey, file.Header.Get("Content-Type"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		uploadError(c, "", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Image updated successfully",
-		"url":     url,
+		"message":        "Image updated successfully",
+		"key":            result.Key,
+		"url":            result.URL,
+		"info":           result.Info,
+		"nearDuplicates": result.NearDuplicates,
+		"optimization":   result.Optimization,
+		"deepZoom":       result.DeepZoom,
 	})
 }
 
+// uploadError responds to a failed upload, listing the
+// matching images when it was rejected as a near-duplicate
+func uploadError(c *gin.Context, prefix string, err error) {
+	body := gin.H{"error": prefix + err.Error()}
+
+	var duplicateErr *service.NearDuplicateError
+	if errors.As(err, &duplicateErr) {
+		body["nearDuplicates"] = duplicateErr.Matches
+	}
+
+	c.JSON(apperrors.Status(err), body)
+}
+
 func (h *ImageHandler) DeleteImage(c *gin.Context) {
-	objName := c.Param("objName")
+	objectKey := c.Param("id")
 
-	err := h.imageService.DeleteImage(c.Request.Context(), objName)
+	err := h.imageService.DeleteImage(c.Request.Context(), objectKey)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
 		return
 	}
 
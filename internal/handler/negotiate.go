@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+)
+
+// acceptedFormats returns the formats we can encode that an Accept header
+// names explicitly, most preferred first. Wildcards such as image/* are
+// left out, since every client that sends them copes with the stored format
+func acceptedFormats(accept string) []string {
+	type accepted struct {
+		format string
+		q      float64
+	}
+
+	var formats []accepted
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, p := range params[1:] {
+			name, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok || strings.TrimSpace(name) != "q" {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		f, ok := imaging.FormatByContentType(mediaType)
+		if !ok || !f.CanEncode() {
+			continue
+		}
+		formats = append(formats, accepted{format: f.Name, q: q})
+	}
+
+	sort.SliceStable(formats, func(i, j int) bool {
+		return formats[i].q > formats[j].q
+	})
+
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.format
+	}
+	return names
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+	"github.com/imkishore16/go-cloudStorage/internal/service"
+)
+
+// cssUnsafe matches the characters of a key that can't go in a class name
+var cssUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// CreateSheet composites the images listed in keys, or every image under
+// prefix, into a contact sheet or CSS sprite stored under key. Sprites are
+// answered with a stylesheet mapping each image to a class
+func (h *ImageHandler) CreateSheet(c *gin.Context) {
+	var req struct {
+		Keys       []string            `json:"keys"`
+		Prefix     string              `json:"prefix"`
+		Key        string              `json:"key" binding:"required"`
+		Layout     imaging.SheetLayout `json:"layout"`
+		Columns    int                 `json:"columns"`
+		CellSize   int                 `json:"cellSize"`
+		Padding    *int                `json:"padding"`
+		Background string              `json:"background"`
+		Format     string              `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	// contact sheets are for looking at, sprites for shipping to browsers
+	opts := imaging.SheetOptions{
+		Layout:     req.Layout,
+		Columns:    req.Columns,
+		CellSize:   req.CellSize,
+		Padding:    8,
+		Background: color.White,
+		Format:     "jpeg",
+	}
+	if opts.Layout == "" {
+		opts.Layout = imaging.SheetContact
+	}
+	if opts.Layout == imaging.SheetSprite {
+		opts.Padding, opts.Background, opts.Format = 2, color.Transparent, "png"
+	}
+	if opts.CellSize == 0 {
+		opts.CellSize = 160
+	}
+	if req.Padding != nil {
+		opts.Padding = *req.Padding
+	}
+	if req.Format != "" {
+		f, err := formatParam(req.Format)
+		if err != nil {
+			c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+			return
+		}
+		opts.Format = f
+	}
+	if req.Background != "" {
+		bg, err := imaging.ParseColor(req.Background)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts.Background = bg
+	}
+
+	sheet, err := h.imageService.CreateSheet(c.Request.Context(), service.SheetRequest{
+		Keys:    req.Keys,
+		Prefix:  req.Prefix,
+		Key:     req.Key,
+		Options: opts,
+	})
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	body := gin.H{"sheet": sheet}
+	if sheet.Layout == string(imaging.SheetSprite) {
+		body["css"] = h.spriteCSS(sheet)
+	}
+	c.JSON(http.StatusCreated, body)
+}
+
+// spriteCSS writes a stylesheet with a .sprite base class and one class
+// per image, named after its key, that shows just that image
+func (h *ImageHandler) spriteCSS(sheet *model.Sheet) string {
+	var css strings.Builder
+	fmt.Fprintf(&css, ".sprite { background-image: url(%q); background-repeat: no-repeat; display: inline-block; }\n",
+		h.baseURL+"/images/"+url.PathEscape(sheet.Key))
+	for _, f := range sheet.Frames {
+		fmt.Fprintf(&css, ".sprite-%s { background-position: -%dpx -%dpx; width: %dpx; height: %dpx; }\n",
+			strings.Trim(cssUnsafe.ReplaceAllString(f.Key, "-"), "-"), f.X, f.Y, f.Width, f.Height)
+	}
+	return css.String()
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+)
+
+// srcsetResource is what srcset requests are signed for. The NUL can't
+// be part of a key, so a srcset signature never passes for a variant
+func srcsetResource(objectKey string) string {
+	return "srcset\x00" + objectKey
+}
+
+// iiifResource is what an IIIF image request is signed for: the image
+// key and the request path after it, kept apart from other resources
+// by the same NUL as srcsetResource
+func iiifResource(objectKey string, path string) string {
+	return "iiif\x00" + objectKey + "/" + path
+}
+
+// signature is the hex HMAC-SHA256 of a resource, the image key for
+// variants, and a query string in canonical order, leaving out the sig
+// parameter itself
+func (h *ImageHandler) signature(resource string, query url.Values) string {
+	canonical := url.Values{}
+	for k, v := range query {
+		if k != "sig" {
+			canonical[k] = v
+		}
+	}
+
+	mac := hmac.New(sha256.New, h.signingKey)
+	mac.Write([]byte(resource + "?" + canonical.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSignature reports whether a request for a resource is signed,
+// which always holds when no signing key is configured
+func (h *ImageHandler) validSignature(resource string, query url.Values) bool {
+	if len(h.signingKey) == 0 {
+		return true
+	}
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil {
+		return false
+	}
+	want, _ := hex.DecodeString(h.signature(resource, query))
+	return hmac.Equal(sig, want)
+}
+
+// variantURL is the URL of a variant of an image, signed
+// when a signing key is configured
+func (h *ImageHandler) variantURL(objectKey string, query url.Values) string {
+	if len(h.signingKey) > 0 {
+		query.Set("sig", h.signature(objectKey, query))
+	}
+	return h.baseURL + "/images/" + url.PathEscape(objectKey) + "?" + query.Encode()
+}
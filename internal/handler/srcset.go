@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// maximum number of widths in one srcset
+const maxSrcsetWidths = 20
+
+// srcsetPresets are the named width sets a srcset can be requested with
+var srcsetPresets = map[string][]int{
+	"default":   {320, 640, 960, 1280, 1920},
+	"thumbnail": {80, 160, 320},
+	"hero":      {640, 1280, 1920, 2560},
+}
+
+// GetSrcset describes the variants of an image at a list of widths, given
+// as widths=320,640 or a named preset, with the srcset and sizes
+// attributes to use them. Missing variants are rendered and cached. The
+// variant URLs come back signed, so with a signing key configured the
+// srcset request has to be signed too, or anyone could mint signatures
+func (h *ImageHandler) GetSrcset(c *gin.Context) {
+	objectKey := c.Param("id")
+	if !h.validSignature(srcsetResource(objectKey), c.Request.URL.Query()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "missing or invalid signature"})
+		return
+	}
+
+	widths, err := srcsetWidths(c.Query("widths"), c.DefaultQuery("preset", "default"))
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+	format, err := formatParam(c.Query("fmt"))
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	variants, err := h.imageService.GetVariants(c.Request.Context(), objectKey, widths, format)
+	if err != nil {
+		c.JSON(apperrors.Status(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	candidates := make([]string, len(variants))
+	for i := range variants {
+		query := url.Values{"w": {strconv.Itoa(variants[i].Width)}}
+		if format != "" {
+			query.Set("fmt", format)
+		}
+		variants[i].URL = h.variantURL(objectKey, query)
+		candidates[i] = fmt.Sprintf("%s %dw", variants[i].URL, variants[i].Width)
+	}
+
+	sizes := c.Query("sizes")
+	if sizes == "" {
+		largest := variants[len(variants)-1].Width
+		sizes = fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", largest, largest)
+	}
+
+	c.JSON(http.StatusOK, model.Srcset{
+		Key:      objectKey,
+		Variants: variants,
+		Srcset:   strings.Join(candidates, ", "),
+		Sizes:    sizes,
+	})
+}
+
+// srcsetWidths reads an explicit list of widths,
+// or the widths of a preset when none are given
+func srcsetWidths(list string, preset string) ([]int, error) {
+	if list == "" {
+		widths, ok := srcsetPresets[preset]
+		if !ok {
+			return nil, apperrors.NewBadRequest(fmt.Sprintf("unknown srcset preset %q", preset))
+		}
+		return widths, nil
+	}
+
+	parts := strings.Split(list, ",")
+	if len(parts) > maxSrcsetWidths {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("at most %d widths can be requested", maxSrcsetWidths))
+	}
+	widths := make([]int, len(parts))
+	for i, p := range parts {
+		w, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || w <= 0 {
+			return nil, apperrors.NewBadRequest("widths must be positive integers")
+		}
+		widths[i] = w
+	}
+	return widths, nil
+}
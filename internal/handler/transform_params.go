@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// transformOptions reads the variant requested in the query string:
+// w and h in pixels, fit, crop as x,y,width,height and fmt
+func transformOptions(c *gin.Context) (imaging.TransformOptions, error) {
+	var opts imaging.TransformOptions
+	var err error
+
+	if opts.Width, err = dimensionParam(c, "w"); err != nil {
+		return opts, err
+	}
+	if opts.Height, err = dimensionParam(c, "h"); err != nil {
+		return opts, err
+	}
+
+	opts.Fit = imaging.Fit(c.Query("fit"))
+	if !opts.Fit.Valid() {
+		return opts, apperrors.NewBadRequest(fmt.Sprintf("unknown fit %q", opts.Fit))
+	}
+
+	if crop := c.Query("crop"); crop != "" {
+		if opts.Crop, err = parseCrop(crop); err != nil {
+			return opts, err
+		}
+	}
+
+	if opts.Format, err = formatParam(c.Query("fmt")); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// formatParam resolves a format given by name or extension, e.g. jpeg or jpg
+func formatParam(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	if f, ok := imaging.FormatByName(v); ok {
+		return f.Name, nil
+	}
+	if f, ok := imaging.FormatByExtension("." + v); ok {
+		return f.Name, nil
+	}
+	return "", apperrors.NewBadRequest(fmt.Sprintf("unknown format %q", v))
+}
+
+func dimensionParam(c *gin.Context, name string) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, apperrors.NewBadRequest(fmt.Sprintf("%s must be a positive integer", name))
+	}
+	return n, nil
+}
+
+func parseCrop(v string) (image.Rectangle, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, apperrors.NewBadRequest("crop must be x,y,width,height")
+	}
+
+	var n [4]int
+	for i, p := range parts {
+		var err error
+		if n[i], err = strconv.Atoi(strings.TrimSpace(p)); err != nil || n[i] < 0 {
+			return image.Rectangle{}, apperrors.NewBadRequest("crop must be x,y,width,height")
+		}
+	}
+	if n[2] == 0 || n[3] == 0 {
+		return image.Rectangle{}, apperrors.NewBadRequest("crop width and height must be positive")
+	}
+
+	return image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3]), nil
+}
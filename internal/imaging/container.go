@@ -0,0 +1,246 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	iccHeader    = []byte("ICC_PROFILE\x00")
+)
+
+// jpegSegment is a single marker segment preceding the scan data
+type jpegSegment struct {
+	Marker  byte
+	Start   int
+	End     int
+	Payload []byte
+}
+
+// jpegSegments walks the marker segments of a JPEG up to the first
+// SOS marker and returns them along with the offset the scan starts at
+func jpegSegments(data []byte) ([]jpegSegment, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, fmt.Errorf("missing JPEG SOI marker")
+	}
+
+	var segments []jpegSegment
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, fmt.Errorf("invalid JPEG marker at offset %d", i)
+		}
+		// skip fill bytes
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			break
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xD9, marker == 0xDA:
+			return segments, i, nil
+		case marker == 0x01, marker >= 0xD0 && marker <= 0xD7:
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, 0, fmt.Errorf("truncated JPEG segment at offset %d", i)
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, fmt.Errorf("invalid JPEG segment length at offset %d", i)
+		}
+
+		segments = append(segments, jpegSegment{
+			Marker:  marker,
+			Start:   i,
+			End:     end,
+			Payload: data[i+4 : end],
+		})
+		i = end
+	}
+
+	return segments, len(data), nil
+}
+
+// pngChunk is a single chunk of a PNG stream
+type pngChunk struct {
+	Type  string
+	Start int
+	End   int
+	Data  []byte
+}
+
+// pngChunks returns every chunk of a PNG stream in order
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("missing PNG signature")
+	}
+
+	var chunks []pngChunk
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("invalid PNG chunk length at offset %d", i)
+		}
+
+		chunk := pngChunk{
+			Type:  string(data[i+4 : i+8]),
+			Start: i,
+			End:   end,
+			Data:  data[i+8 : i+8+length],
+		}
+		chunks = append(chunks, chunk)
+		i = end
+
+		if chunk.Type == "IEND" {
+			break
+		}
+	}
+
+	return chunks, nil
+}
+
+// riffChunk is a single chunk of a RIFF (WebP) container
+type riffChunk struct {
+	FourCC string
+	Start  int
+	End    int
+	Data   []byte
+}
+
+// riffChunks returns the chunks of a WebP file
+func riffChunks(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("missing RIFF/WEBP header")
+	}
+
+	var chunks []riffChunk
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		dataEnd := i + 8 + size
+		if size < 0 || dataEnd > len(data) {
+			return nil, fmt.Errorf("invalid RIFF chunk size at offset %d", i)
+		}
+
+		// chunks are padded to an even size
+		end := dataEnd + size%2
+		if end > len(data) {
+			end = len(data)
+		}
+
+		chunks = append(chunks, riffChunk{
+			FourCC: string(data[i : i+4]),
+			Start:  i,
+			End:    end,
+			Data:   data[i+8 : dataEnd],
+		})
+		i = end
+	}
+
+	return chunks, nil
+}
+
+// exifPayload locates the raw TIFF-structured EXIF block of an image
+func exifPayload(data []byte, format string) []byte {
+	switch format {
+	case "jpeg":
+		segments, _, err := jpegSegments(data)
+		if err != nil {
+			return nil
+		}
+		for _, s := range segments {
+			if s.Marker == 0xE1 && bytes.HasPrefix(s.Payload, exifHeader) {
+				return s.Payload[len(exifHeader):]
+			}
+		}
+	case "png":
+		chunks, err := pngChunks(data)
+		if err != nil {
+			return nil
+		}
+		for _, c := range chunks {
+			if c.Type == "eXIf" {
+				return c.Data
+			}
+		}
+	case "webp":
+		chunks, err := riffChunks(data)
+		if err != nil {
+			return nil
+		}
+		for _, c := range chunks {
+			if c.FourCC == "EXIF" {
+				return bytes.TrimPrefix(c.Data, exifHeader)
+			}
+		}
+	case "tiff":
+		// a TIFF file is laid out the same way as an EXIF block
+		return data
+	}
+
+	return nil
+}
+
+// hasICCProfile reports whether the image embeds an ICC color profile
+func hasICCProfile(data []byte, format string) bool {
+	switch format {
+	case "jpeg":
+		segments, _, err := jpegSegments(data)
+		if err != nil {
+			return false
+		}
+		for _, s := range segments {
+			if s.Marker == 0xE2 && bytes.HasPrefix(s.Payload, iccHeader) {
+				return true
+			}
+		}
+	case "png":
+		chunks, err := pngChunks(data)
+		if err != nil {
+			return false
+		}
+		for _, c := range chunks {
+			if c.Type == "iCCP" {
+				return true
+			}
+		}
+	case "webp":
+		chunks, err := riffChunks(data)
+		if err != nil {
+			return false
+		}
+		for _, c := range chunks {
+			if c.FourCC == "ICCP" {
+				return true
+			}
+		}
+	case "tiff":
+		t, err := newTIFF(data)
+		if err != nil {
+			return false
+		}
+		entries, _, err := t.ifd(t.firstIFD())
+		if err != nil {
+			return false
+		}
+		for _, e := range entries {
+			if e.Tag == tagICCProfile {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+const (
+	// tile size and format used by the reference Deep Zoom tools
+	defaultTileSize   = 254
+	defaultTileFormat = "jpeg"
+	deepZoomNamespace = "http://schemas.microsoft.com/deepzoom/2008"
+	// largest tile side, well past what any viewer asks for
+	maxDeepZoomTileSize = 4096
+)
+
+// DeepZoomOptions configures the DZI tile pyramid of an image. Tiles are
+// TileSize pixels square plus Overlap pixels shared with each neighbour,
+// and written in Format. Images whose longest side is under MinSize are
+// not tiled. Zero values of TileSize and Format take the defaults
+type DeepZoomOptions struct {
+	TileSize int    `json:"tileSize,omitempty"`
+	Overlap  int    `json:"overlap,omitempty"`
+	Format   string `json:"format,omitempty"`
+	MinSize  int    `json:"minSize,omitempty"`
+}
+
+// Valid reports whether the options are in range and name a format
+// tiles can be written in
+func (o DeepZoomOptions) Valid() bool {
+	if o.TileSize < 0 || o.TileSize > maxDeepZoomTileSize || o.Overlap < 0 || o.MinSize < 0 {
+		return false
+	}
+	if o.Format == "" {
+		return true
+	}
+	f, ok := FormatByName(o.Format)
+	return ok && f.CanEncode()
+}
+
+func (o DeepZoomOptions) withDefaults() DeepZoomOptions {
+	if o.TileSize == 0 {
+		o.TileSize = defaultTileSize
+	}
+	if o.Format == "" {
+		o.Format = defaultTileFormat
+	}
+	return o
+}
+
+// Wants reports whether a width x height image gets a pyramid
+func (o DeepZoomOptions) Wants(width, height int) bool {
+	return max(width, height) >= o.MinSize
+}
+
+type dziImage struct {
+	XMLName  xml.Name `xml:"Image"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	TileSize int      `xml:"TileSize,attr"`
+	Overlap  int      `xml:"Overlap,attr"`
+	Format   string   `xml:"Format,attr"`
+	Size     struct {
+		Width  int `xml:"Width,attr"`
+		Height int `xml:"Height,attr"`
+	} `xml:"Size"`
+}
+
+// DeepZoom cuts an image into a Deep Zoom tile pyramid. Level 0 is a
+// single pixel and each level doubles the previous one up to the full
+// image. Every tile is handed to put under its path within the pyramid,
+// "<level>/<column>_<row>.<ext>", and the .dzi descriptor is returned
+// along with the size of the pyramid
+func DeepZoom(data []byte, opts DeepZoomOptions, put func(name string, tile []byte) error) ([]byte, *model.DeepZoom, error) {
+	opts = opts.withDefaults()
+	format, ok := FormatByName(opts.Format)
+	if !ok || !format.CanEncode() {
+		return nil, nil, fmt.Errorf("can't write %s tiles", opts.Format)
+	}
+	ext := strings.TrimPrefix(format.Extension(), ".")
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	maxLevel := int(math.Ceil(math.Log2(float64(max(width, height)))))
+	pyramid := &model.DeepZoom{Levels: maxLevel + 1}
+	level := resize(img, b, width, height)
+	for l := maxLevel; l >= 0; l-- {
+		tiles, err := cutTiles(level, l, opts, format, ext, put)
+		if err != nil {
+			return nil, nil, err
+		}
+		pyramid.Tiles += tiles
+
+		// every level is half the one above, rounded up
+		lw, lh := level.Rect.Dx(), level.Rect.Dy()
+		if l > 0 {
+			level = resize(level, level.Rect, (lw+1)/2, (lh+1)/2)
+		}
+	}
+
+	descriptor := dziImage{
+		Xmlns:    deepZoomNamespace,
+		TileSize: opts.TileSize,
+		Overlap:  opts.Overlap,
+		Format:   ext,
+	}
+	descriptor.Size.Width, descriptor.Size.Height = width, height
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(descriptor); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode DZI descriptor: %v", err)
+	}
+	return buf.Bytes(), pyramid, nil
+}
+
+// cutTiles splits one level of the pyramid into tiles and
+// returns how many there were
+func cutTiles(img *image.NRGBA, level int, opts DeepZoomOptions, format Format, ext string, put func(string, []byte) error) (int, error) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	tiles := 0
+	for col := 0; col*opts.TileSize < w; col++ {
+		for row := 0; row*opts.TileSize < h; row++ {
+			tile := image.Rect(
+				col*opts.TileSize-opts.Overlap, row*opts.TileSize-opts.Overlap,
+				(col+1)*opts.TileSize+opts.Overlap, (row+1)*opts.TileSize+opts.Overlap,
+			).Intersect(img.Rect)
+
+			var buf bytes.Buffer
+			if err := format.Encode(&buf, prepareForEncode(img.SubImage(tile), format)); err != nil {
+				return 0, fmt.Errorf("failed to encode tile: %v", err)
+			}
+			if err := put(fmt.Sprintf("%d/%d_%d.%s", level, col, row, ext), buf.Bytes()); err != nil {
+				return 0, err
+			}
+			tiles++
+		}
+	}
+	return tiles, nil
+}
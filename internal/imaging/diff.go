@@ -0,0 +1,236 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+const (
+	// side of the cells changed pixels are grouped in, so that changes a
+	// few pixels apart end up in one bounding box
+	diffCellSize = 8
+	// most bounding boxes reported, largest first
+	maxDiffBoxes = 100
+	// side of the windows SSIM is computed over
+	ssimWindow = 8
+)
+
+// SSIM stabilizing constants for 8-bit samples
+var (
+	ssimC1 = math.Pow(0.01*255, 2)
+	ssimC2 = math.Pow(0.03*255, 2)
+)
+
+// highlight is the color changed pixels are painted in the diff image
+var highlight = color.NRGBA{R: 0xff, G: 0x00, B: 0xff, A: 0xff}
+
+// Diff compares two images pixel by pixel. A pixel counts as changed when
+// any of its channels differs by more than tolerance. Images of different
+// sizes are compared over the larger canvas, where pixels only one of them
+// covers count as changed. It returns a PNG of the base image, faded, with
+// the changed pixels highlighted, and the statistics of the comparison
+func Diff(base, compare []byte, tolerance int) ([]byte, *model.ImageDiff, error) {
+	a, err := decodeNRGBA(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := decodeNRGBA(compare)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	width, height := max(a.Rect.Dx(), b.Rect.Dx()), max(a.Rect.Dy(), b.Rect.Dy())
+	common := a.Rect.Intersect(b.Rect)
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	cols, rows := (width+diffCellSize-1)/diffCellSize, (height+diffCellSize-1)/diffCellSize
+	cells := make([]model.DiffBox, cols*rows)
+
+	changed := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := image.Pt(x, y)
+			in := p.In(common)
+			if in && !pixelChanged(a, b, x, y, tolerance) {
+				out.SetNRGBA(x, y, faded(a, p))
+				continue
+			}
+
+			changed++
+			out.SetNRGBA(x, y, highlight)
+			cell := &cells[y/diffCellSize*cols+x/diffCellSize]
+			*cell = unionBox(*cell, model.DiffBox{X: x, Y: y, Width: 1, Height: 1})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode diff image: %v", err)
+	}
+
+	total := width * height
+	diff := &model.ImageDiff{
+		Width:          width,
+		Height:         height,
+		SizeMismatch:   a.Rect != b.Rect,
+		Tolerance:      tolerance,
+		ChangedPixels:  changed,
+		TotalPixels:    total,
+		ChangedPercent: math.Round(float64(changed)/float64(total)*1e4) / 100,
+		Boxes:          changedBoxes(cells, cols, rows),
+		SSIM:           math.Round(ssim(a, b, common)*1e4) / 1e4,
+	}
+	if mse := meanSquaredError(a, b, common); mse > 0 {
+		psnr := math.Round(10*math.Log10(255*255/mse)*100) / 100
+		diff.PSNR = &psnr
+	}
+	return buf.Bytes(), diff, nil
+}
+
+func decodeNRGBA(data []byte) (*image.NRGBA, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	b := img.Bounds()
+	return resize(img, b, b.Dx(), b.Dy()), nil
+}
+
+func pixelChanged(a, b *image.NRGBA, x, y int, tolerance int) bool {
+	i, j := a.PixOffset(x, y), b.PixOffset(x, y)
+	for c := 0; c < 4; c++ {
+		if d := int(a.Pix[i+c]) - int(b.Pix[j+c]); d > tolerance || -d > tolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// faded is a pixel of img washed out towards white, as the
+// backdrop the highlighted changes are shown against
+func faded(img *image.NRGBA, p image.Point) color.NRGBA {
+	c := img.NRGBAAt(p.X, p.Y)
+	gray := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+	gray = 255 - (255-gray)*int(c.A)/255/3
+	return color.NRGBA{R: uint8(gray), G: uint8(gray), B: uint8(gray), A: 0xff}
+}
+
+// changedBoxes merges touching cells with changes into bounding
+// boxes around the changed pixels, largest first
+func changedBoxes(cells []model.DiffBox, cols, rows int) []model.DiffBox {
+	seen := make([]bool, len(cells))
+	boxes := []model.DiffBox{}
+	for start := range cells {
+		if seen[start] || cells[start].Width == 0 {
+			continue
+		}
+
+		box := cells[start]
+		seen[start] = true
+		queue := []int{start}
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+			cx, cy := i%cols, i/cols
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := cx+dx, cy+dy
+					if nx < 0 || ny < 0 || nx >= cols || ny >= rows {
+						continue
+					}
+					n := ny*cols + nx
+					if seen[n] || cells[n].Width == 0 {
+						continue
+					}
+					seen[n] = true
+					box = unionBox(box, cells[n])
+					queue = append(queue, n)
+				}
+			}
+		}
+		boxes = append(boxes, box)
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxes[i].Width*boxes[i].Height > boxes[j].Width*boxes[j].Height
+	})
+	if len(boxes) > maxDiffBoxes {
+		boxes = boxes[:maxDiffBoxes]
+	}
+	return boxes
+}
+
+// unionBox is the smallest box holding both a and b. Empty boxes
+// have zero width
+func unionBox(a, b model.DiffBox) model.DiffBox {
+	if a.Width == 0 {
+		return b
+	}
+	r := image.Rect(a.X, a.Y, a.X+a.Width, a.Y+a.Height).Union(image.Rect(b.X, b.Y, b.X+b.Width, b.Y+b.Height))
+	return model.DiffBox{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}
+}
+
+// meanSquaredError over the RGB channels of the region both images cover
+func meanSquaredError(a, b *image.NRGBA, region image.Rectangle) float64 {
+	if region.Empty() {
+		return 0
+	}
+	var sum float64
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			i, j := a.PixOffset(x, y), b.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				d := float64(a.Pix[i+c]) - float64(b.Pix[j+c])
+				sum += d * d
+			}
+		}
+	}
+	return sum / float64(region.Dx()*region.Dy()*3)
+}
+
+// ssim is the mean structural similarity of the luma of both images
+// over non-overlapping windows of the region they both cover
+func ssim(a, b *image.NRGBA, region image.Rectangle) float64 {
+	var total float64
+	windows := 0
+	for wy := region.Min.Y; wy < region.Max.Y; wy += ssimWindow {
+		for wx := region.Min.X; wx < region.Max.X; wx += ssimWindow {
+			window := image.Rect(wx, wy, wx+ssimWindow, wy+ssimWindow).Intersect(region)
+			n := float64(window.Dx() * window.Dy())
+
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := window.Min.Y; y < window.Max.Y; y++ {
+				for x := window.Min.X; x < window.Max.X; x++ {
+					la, lb := luma(a, x, y), luma(b, x, y)
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA, varB := sumAA/n-meanA*meanA, sumBB/n-meanB*meanB
+			cov := sumAB/n - meanA*meanB
+
+			total += (2*meanA*meanB + ssimC1) * (2*cov + ssimC2) /
+				((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 0
+	}
+	return total / float64(windows)
+}
+
+func luma(img *image.NRGBA, x, y int) float64 {
+	i := img.PixOffset(x, y)
+	return 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+}
@@ -0,0 +1,263 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+// EXIF tags we read out of IFD0, the EXIF sub-IFD and the GPS IFD
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagICCProfile       = 0x8773
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// size in bytes of each TIFF field type, indexed by type id
+var tiffTypeSize = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// tiffBlock is a TIFF-structured block, as found in EXIF payloads
+type tiffBlock struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is one field of an image file directory. ValueOffset is
+// the position of the field's value within the TIFF block, which is
+// either inside the entry itself or out of line
+type ifdEntry struct {
+	Offset      int
+	Tag         uint16
+	Type        uint16
+	Count       uint32
+	ValueOffset int
+}
+
+func newTIFF(b []byte) (*tiffBlock, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("EXIF block too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(b[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid EXIF byte order")
+	}
+	if order.Uint16(b[2:]) != 42 {
+		return nil, fmt.Errorf("invalid EXIF magic number")
+	}
+
+	return &tiffBlock{b: b, order: order}, nil
+}
+
+// firstIFD returns the offset of IFD0
+func (t *tiffBlock) firstIFD() int {
+	return int(t.order.Uint32(t.b[4:]))
+}
+
+// ifd reads the directory at offset and the offset of the next one
+func (t *tiffBlock) ifd(offset int) ([]ifdEntry, int, error) {
+	if offset <= 0 || offset+2 > len(t.b) {
+		return nil, 0, fmt.Errorf("IFD offset %d out of range", offset)
+	}
+
+	count := int(t.order.Uint16(t.b[offset:]))
+	end := offset + 2 + count*12
+	if end+4 > len(t.b) {
+		return nil, 0, fmt.Errorf("IFD at offset %d is truncated", offset)
+	}
+
+	entries := make([]ifdEntry, 0, count)
+	for i := 0; i < count; i++ {
+		p := offset + 2 + i*12
+		e := ifdEntry{
+			Offset:      p,
+			Tag:         t.order.Uint16(t.b[p:]),
+			Type:        t.order.Uint16(t.b[p+2:]),
+			Count:       t.order.Uint32(t.b[p+4:]),
+			ValueOffset: p + 8,
+		}
+		if t.size(e) > 4 {
+			e.ValueOffset = int(t.order.Uint32(t.b[p+8:]))
+		}
+		if e.ValueOffset+t.size(e) > len(t.b) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, int(t.order.Uint32(t.b[end:])), nil
+}
+
+// size is the number of bytes taken by an entry's value
+func (t *tiffBlock) size(e ifdEntry) int {
+	if int(e.Type) >= len(tiffTypeSize) {
+		return 0
+	}
+	return tiffTypeSize[e.Type] * int(e.Count)
+}
+
+func (t *tiffBlock) ascii(e ifdEntry) string {
+	v := t.b[e.ValueOffset : e.ValueOffset+t.size(e)]
+	return strings.TrimSpace(strings.TrimRight(string(v), "\x00"))
+}
+
+// uint reads the first value of a BYTE, SHORT or LONG entry
+func (t *tiffBlock) uint(e ifdEntry) uint32 {
+	if e.Count == 0 {
+		return 0
+	}
+	switch e.Type {
+	case 1, 7:
+		return uint32(t.b[e.ValueOffset])
+	case 3:
+		return uint32(t.order.Uint16(t.b[e.ValueOffset:]))
+	case 4:
+		return t.order.Uint32(t.b[e.ValueOffset:])
+	}
+	return 0
+}
+
+// rationals reads the values of a RATIONAL entry
+func (t *tiffBlock) rationals(e ifdEntry) []float64 {
+	if e.Type != 5 {
+		return nil
+	}
+	values := make([]float64, e.Count)
+	for i := range values {
+		p := e.ValueOffset + i*8
+		num := t.order.Uint32(t.b[p:])
+		den := t.order.Uint32(t.b[p+4:])
+		if den != 0 {
+			values[i] = float64(num) / float64(den)
+		}
+	}
+	return values
+}
+
+// parseExif extracts the fields of model.Exif from a raw EXIF block
+func parseExif(raw []byte) (*model.Exif, error) {
+	t, err := newTIFF(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ifd0, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return nil, err
+	}
+
+	exif := &model.Exif{}
+	var dateTime string
+	for _, e := range ifd0 {
+		switch e.Tag {
+		case tagMake:
+			exif.Make = t.ascii(e)
+		case tagModel:
+			exif.Model = t.ascii(e)
+		case tagOrientation:
+			exif.Orientation = int(t.uint(e))
+		case tagDateTime:
+			dateTime = t.ascii(e)
+		case tagExifIFD:
+			if original := t.dateTimeOriginal(int(t.uint(e))); original != "" {
+				dateTime = original
+			}
+		case tagGPSIFD:
+			exif.GPS = t.gps(int(t.uint(e)))
+		}
+	}
+
+	if captured, err := time.Parse(exifTimeLayout, dateTime); err == nil {
+		exif.CapturedAt = &captured
+	}
+
+	return exif, nil
+}
+
+func (t *tiffBlock) dateTimeOriginal(offset int) string {
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if e.Tag == tagDateTimeOriginal {
+			return t.ascii(e)
+		}
+	}
+	return ""
+}
+
+func (t *tiffBlock) gps(offset int) *model.GPS {
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return nil
+	}
+
+	var latRef, lonRef string
+	var lat, lon []float64
+	var alt *float64
+	var altBelowSeaLevel bool
+	for _, e := range entries {
+		switch e.Tag {
+		case tagGPSLatitudeRef:
+			latRef = t.ascii(e)
+		case tagGPSLatitude:
+			lat = t.rationals(e)
+		case tagGPSLongitudeRef:
+			lonRef = t.ascii(e)
+		case tagGPSLongitude:
+			lon = t.rationals(e)
+		case tagGPSAltitudeRef:
+			altBelowSeaLevel = t.uint(e) == 1
+		case tagGPSAltitude:
+			if v := t.rationals(e); len(v) > 0 {
+				alt = &v[0]
+			}
+		}
+	}
+
+	if len(lat) != 3 || len(lon) != 3 {
+		return nil
+	}
+
+	gps := &model.GPS{
+		Latitude:  lat[0] + lat[1]/60 + lat[2]/3600,
+		Longitude: lon[0] + lon[1]/60 + lon[2]/3600,
+	}
+	if latRef == "S" {
+		gps.Latitude = -gps.Latitude
+	}
+	if lonRef == "W" {
+		gps.Longitude = -gps.Longitude
+	}
+	if alt != nil {
+		if altBelowSeaLevel {
+			*alt = -*alt
+		}
+		gps.Altitude = alt
+	}
+
+	return gps
+}
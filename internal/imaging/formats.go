@@ -0,0 +1,172 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Format describes an image format the service can store. Name is the
+// format name reported by image.Decode, and the first extension is the
+// one used when writing files of the format
+type Format struct {
+	Name        string
+	ContentType string
+	Extensions  []string
+	encode      func(w io.Writer, img image.Image) error
+}
+
+// formats is the registry of every supported image format. Importing
+// this package registers a decoder with image.Decode for each of them
+// but SVG, which is stored and served as sanitized XML, never rasterized
+var formats = []Format{
+	{
+		Name:        "jpeg",
+		ContentType: "image/jpeg",
+		Extensions:  []string{".jpg", ".jpeg", ".jpe", ".jfif"},
+		encode: func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: reencodeQuality})
+		},
+	},
+	{
+		Name:        "png",
+		ContentType: "image/png",
+		Extensions:  []string{".png"},
+		encode:      png.Encode,
+	},
+	{
+		Name:        "gif",
+		ContentType: "image/gif",
+		Extensions:  []string{".gif"},
+		encode: func(w io.Writer, img image.Image) error {
+			return gif.Encode(w, img, nil)
+		},
+	},
+	{
+		// WebP is written lossless, since the only pure Go encoder is VP8L
+		Name:        "webp",
+		ContentType: "image/webp",
+		Extensions:  []string{".webp"},
+		encode: func(w io.Writer, img image.Image) error {
+			return nativewebp.Encode(w, img, nil)
+		},
+	},
+	{
+		Name:        "bmp",
+		ContentType: "image/bmp",
+		Extensions:  []string{".bmp"},
+		encode:      bmp.Encode,
+	},
+	{
+		Name:        "tiff",
+		ContentType: "image/tiff",
+		Extensions:  []string{".tif", ".tiff"},
+		encode: func(w io.Writer, img image.Image) error {
+			return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+		},
+	},
+	{
+		Name:        "svg",
+		ContentType: SVGContentType,
+		Extensions:  []string{".svg"},
+	},
+}
+
+// Extension returns the extension used when writing files of the format
+func (f Format) Extension() string {
+	return f.Extensions[0]
+}
+
+// CanEncode reports whether images can be written in the format
+func (f Format) CanEncode() bool {
+	return f.encode != nil
+}
+
+// Encode writes img in the format
+func (f Format) Encode(w io.Writer, img image.Image) error {
+	if f.encode == nil {
+		return fmt.Errorf("encoding %s images is not supported", f.Name)
+	}
+	return f.encode(w, img)
+}
+
+// FormatByName looks a format up by the name reported by image.Decode
+func FormatByName(name string) (Format, bool) {
+	for _, f := range formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// FormatByContentType looks a format up by its MIME type
+func FormatByContentType(contentType string) (Format, bool) {
+	for _, f := range formats {
+		if f.ContentType == contentType {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// FormatByExtension looks a format up by a file extension such as ".jpg"
+func FormatByExtension(ext string) (Format, bool) {
+	ext = strings.ToLower(ext)
+	for _, f := range formats {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+// IsImageContentType reports whether contentType is a supported image format
+func IsImageContentType(contentType string) bool {
+	_, ok := FormatByContentType(contentType)
+	return ok
+}
+
+// ExtensionForContentType returns the file extension for a supported
+// image content type, or the empty string for anything else
+func ExtensionForContentType(contentType string) string {
+	f, ok := FormatByContentType(contentType)
+	if !ok {
+		return ""
+	}
+	return f.Extension()
+}
+
+// DetectContentType sniffs the content type of data. It extends
+// http.DetectContentType with the image formats it doesn't know about
+func DetectContentType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	if IsSVG(data) {
+		return SVGContentType
+	}
+	return http.DetectContentType(data)
+}
+
+// Encode writes img in the named format
+func Encode(w io.Writer, img image.Image, format string) error {
+	f, ok := FormatByName(format)
+	if !ok {
+		return fmt.Errorf("unsupported image format: %s", format)
+	}
+	return f.Encode(w, img)
+}
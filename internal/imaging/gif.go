@@ -0,0 +1,220 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// IsAnimated reports whether data is a GIF with more than one frame
+func IsAnimated(data []byte) bool {
+	frames, err := gifFrameCount(data)
+	return err == nil && frames > 1
+}
+
+// gifFrameCount counts the frames of a GIF by walking its blocks,
+// without decompressing any image data
+func gifFrameCount(data []byte) (int, error) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return 0, fmt.Errorf("missing GIF header")
+	}
+
+	i := 13
+	if packed := data[10]; packed&0x80 != 0 {
+		i += 3 << ((packed & 0x07) + 1)
+	}
+
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label then sub-blocks
+			end, err := skipSubBlocks(data, i+2)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case 0x2C: // image descriptor, optional local color table, LZW data
+			if i+10 > len(data) {
+				return 0, fmt.Errorf("truncated GIF image descriptor")
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << ((packed & 0x07) + 1)
+			}
+			end, err := skipSubBlocks(data, i+1)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+			frames++
+		case 0x3B: // trailer
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("invalid GIF block 0x%02x", data[i])
+		}
+	}
+
+	return frames, nil
+}
+
+// skipSubBlocks returns the offset following a chain of data sub-blocks
+func skipSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, fmt.Errorf("truncated GIF data")
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
+
+// animationInfo summarizes the timing of an animated GIF
+func animationInfo(g *gif.GIF) *model.Animation {
+	total := 0
+	for _, delay := range g.Delay {
+		total += delay
+	}
+	return &model.Animation{
+		LoopCount:  g.LoopCount,
+		DurationMs: total * 10,
+	}
+}
+
+// composeGIF renders each frame of an animation onto the full canvas,
+// honoring the disposal method of the frame before it. The canvas
+// passed to fn is reused, so fn must copy it to retain it
+func composeGIF(g *gif.GIF, fn func(i int, canvas *image.NRGBA) error) error {
+	canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+
+	for i, frame := range g.Image {
+		var previous *image.NRGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if err := fn(i, canvas); err != nil {
+			return err
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return nil
+}
+
+// transformGIF applies a transform to every frame of an animation,
+// keeping each frame's palette, delay and the loop count
+func transformGIF(g *gif.GIF, region image.Rectangle, w, h int, opts TransformOptions) (*gif.GIF, error) {
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, 0, len(g.Image)),
+		Delay:     g.Delay,
+		LoopCount: g.LoopCount,
+		Config:    image.Config{Width: w, Height: h},
+	}
+
+	err := composeGIF(g, func(i int, canvas *image.NRGBA) error {
+		resized := opts.finish(resize(canvas, region, w, h))
+		// quarter turns swap the canvas dimensions
+		out.Config.Width, out.Config.Height = resized.Rect.Dx(), resized.Rect.Dy()
+
+		palette := g.Image[i].Palette
+		if !hasTransparent(palette) && len(palette) < 256 {
+			palette = append(color.Palette{color.Transparent}, palette...)
+		}
+		frame := image.NewPaletted(resized.Bounds(), palette)
+		draw.Draw(frame, frame.Bounds(), resized, image.Point{}, draw.Src)
+
+		out.Image = append(out.Image, frame)
+		// every frame is a full canvas, so clear it before drawing the next
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func hasTransparent(p color.Palette) bool {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Frame renders a single frame of an animated GIF, composited onto the
+// full canvas, and encodes it in the named format. Index 0 is the poster
+// frame. Still images only have frame 0
+func Frame(data []byte, index int, format string) ([]byte, Format, error) {
+	out, ok := FormatByName(format)
+	if !ok || !out.CanEncode() {
+		return nil, Format{}, apperrors.NewBadRequest(fmt.Sprintf("unsupported output format: %s", format))
+	}
+
+	_, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, Format{}, fmt.Errorf("failed to decode image header: %v", err)
+	}
+
+	var frame image.Image
+	if name == "gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, Format{}, fmt.Errorf("failed to decode GIF frames: %v", err)
+		}
+		if index < 0 || index >= len(g.Image) {
+			return nil, Format{}, apperrors.NewNotFound("frame", fmt.Sprint(index))
+		}
+
+		err = composeGIF(g, func(i int, canvas *image.NRGBA) error {
+			if i == index {
+				f := image.NewNRGBA(canvas.Bounds())
+				copy(f.Pix, canvas.Pix)
+				frame = f
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, Format{}, err
+		}
+	} else {
+		if index != 0 {
+			return nil, Format{}, apperrors.NewNotFound("frame", fmt.Sprint(index))
+		}
+		frame, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, Format{}, fmt.Errorf("failed to decode image: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := out.Encode(&buf, prepareForEncode(frame, out)); err != nil {
+		return nil, Format{}, fmt.Errorf("failed to encode %s image: %v", out.Name, err)
+	}
+	return buf.Bytes(), out, nil
+}
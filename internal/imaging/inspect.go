@@ -0,0 +1,99 @@
+// Package imaging holds the image processing used by the service layer:
+// decoding headers, reading EXIF and embedded profiles out of uploads.
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+// Inspect decodes an image and collects the properties
+// persisted alongside the object on upload
+func Inspect(data []byte) (*model.ImageInfo, error) {
+	if IsSVG(data) {
+		return inspectSVG(data)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image header: %v", err)
+	}
+
+	info := &model.ImageInfo{
+		Width:         cfg.Width,
+		Height:        cfg.Height,
+		Format:        format,
+		ColorModel:    colorModelName(cfg.ColorModel),
+		Size:          int64(len(data)),
+		ContentType:   DetectContentType(data),
+		HasICCProfile: hasICCProfile(data, format),
+	}
+
+	if format == "gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GIF frames: %v", err)
+		}
+		info.FrameCount = len(g.Image)
+		if info.FrameCount > 1 {
+			info.Animation = animationInfo(g)
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	info.Hashes = perceptualHashes(img)
+	info.BlurHash = blurHash(img)
+	info.Palette = dominantColors(img)
+	if info.LQIP, err = lqip(img); err != nil {
+		return nil, fmt.Errorf("failed to encode placeholder: %v", err)
+	}
+
+	if raw := exifPayload(data, format); raw != nil {
+		// a broken EXIF block shouldn't reject an otherwise valid image
+		if exif, err := parseExif(raw); err == nil {
+			info.Exif = exif
+		}
+	}
+
+	return info, nil
+}
+
+func colorModelName(m color.Model) string {
+	if _, ok := m.(color.Palette); ok {
+		return "paletted"
+	}
+
+	switch m {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	case color.CMYKModel:
+		return "cmyk"
+	}
+	return "unknown"
+}
@@ -0,0 +1,253 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+// Optimization methods reported in model.Optimization
+const (
+	OptimizeNone          = "none"
+	OptimizePNGRecompress = "png-recompress"
+	OptimizePNGQuantize   = "png-quantize"
+	OptimizeJPEGReencode  = "jpeg-reencode"
+)
+
+// most opaque pixels sampled when building a quantization palette
+const quantizeSamples = 1 << 16
+
+// ancillary PNG chunks that describe the image rather than its pixel
+// layout, and so stay valid on a re-encoded image
+var portablePNGChunks = map[string]bool{
+	"cHRM": true, "gAMA": true, "iCCP": true, "sRGB": true, "pHYs": true,
+	"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true,
+}
+
+// OptimizeOptions controls how far Optimize may go beyond lossless PNG
+// recompression. PNGs are quantized to an 8-bit palette when the RMS error
+// of the dithered result stays under MaxQuantizeError (on a 0-255 scale),
+// and JPEGs are re-encoded at JPEGQuality. Zero values disable either step
+type OptimizeOptions struct {
+	MaxQuantizeError float64 `json:"maxQuantizeError,omitempty"`
+	JPEGQuality      int     `json:"jpegQuality,omitempty"`
+}
+
+// Valid reports whether the options are in range
+func (o OptimizeOptions) Valid() bool {
+	return o.MaxQuantizeError >= 0 && o.MaxQuantizeError <= 255 && o.JPEGQuality >= 0 && o.JPEGQuality <= 100
+}
+
+// Optimize shrinks a PNG or JPEG image, keeping its format and metadata.
+// The smallest candidate wins and the image is returned unchanged when
+// none of them is smaller. Other formats and animated PNGs are left alone
+func Optimize(data []byte, opts OptimizeOptions) ([]byte, *model.Optimization, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode image header: %v", err)
+	}
+
+	var optimized []byte
+	method := OptimizeNone
+	switch format {
+	case "png":
+		optimized, method, err = optimizePNG(data, opts)
+	case "jpeg":
+		if opts.JPEGQuality > 0 {
+			optimized, err = optimizeJPEG(data, opts.JPEGQuality)
+			method = OptimizeJPEGReencode
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if optimized == nil || len(optimized) >= len(data) {
+		optimized, method = data, OptimizeNone
+	}
+	return optimized, &model.Optimization{
+		Method:         method,
+		OriginalBytes:  int64(len(data)),
+		OptimizedBytes: int64(len(optimized)),
+		BytesSaved:     int64(len(data) - len(optimized)),
+	}, nil
+}
+
+func optimizePNG(data []byte, opts OptimizeOptions) ([]byte, string, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var metadata bytes.Buffer
+	for _, c := range chunks {
+		// re-encoding keeps only the first frame of an APNG
+		if c.Type == "acTL" {
+			return nil, OptimizeNone, nil
+		}
+		if portablePNGChunks[c.Type] {
+			metadata.Write(data[c.Start:c.End])
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	best, err := encodeBestPNG(img)
+	if err != nil {
+		return nil, "", err
+	}
+	method := OptimizePNGRecompress
+
+	if opts.MaxQuantizeError > 0 {
+		if paletted, ok := quantize(img, opts.MaxQuantizeError); ok {
+			candidate, err := encodeBestPNG(paletted)
+			if err != nil {
+				return nil, "", err
+			}
+			if len(candidate) < len(best) {
+				best, method = candidate, OptimizePNGQuantize
+			}
+		}
+	}
+
+	encoded, err := pngChunks(best)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read encoded PNG image: %v", err)
+	}
+
+	// IHDR is always the first chunk
+	ihdrEnd := encoded[0].End
+	var out bytes.Buffer
+	out.Write(best[:ihdrEnd])
+	metadata.WriteTo(&out)
+	out.Write(best[ihdrEnd:])
+	return out.Bytes(), method, nil
+}
+
+func encodeBestPNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// quantize reduces img to an adaptive 256 color palette built by median
+// cut, with Floyd-Steinberg dithering. It gives up on images with partial
+// transparency, which a single transparent palette entry can't represent,
+// and when the RMS error of the result exceeds maxError
+func quantize(img image.Image, maxError float64) (*image.Paletted, bool) {
+	b := img.Bounds()
+	src := resize(img, b, b.Dx(), b.Dy())
+
+	transparent := false
+	opaque := 0
+	for i := 3; i < len(src.Pix); i += 4 {
+		switch src.Pix[i] {
+		case 0:
+			transparent = true
+		case 0xff:
+			opaque++
+		default:
+			return nil, false
+		}
+	}
+
+	colors := 256
+	if transparent {
+		colors--
+	}
+	stride := max(1, opaque/quantizeSamples)
+	samples := make([][3]float64, 0, min(opaque, quantizeSamples+1))
+	for i, n := 0, 0; i < len(src.Pix); i += 4 {
+		if src.Pix[i+3] == 0 {
+			continue
+		}
+		if n%stride == 0 {
+			samples = append(samples, [3]float64{float64(src.Pix[i]), float64(src.Pix[i+1]), float64(src.Pix[i+2])})
+		}
+		n++
+	}
+
+	var palette color.Palette
+	if transparent {
+		palette = append(palette, color.Transparent)
+	}
+	if len(samples) > 0 {
+		// boxes split at the median can share a color, so drop repeats
+		seen := make(map[color.RGBA]bool)
+		for _, c := range medianCut(samples, colors) {
+			entry := color.RGBA{
+				R: uint8(math.Round(c[0])),
+				G: uint8(math.Round(c[1])),
+				B: uint8(math.Round(c[2])),
+				A: 0xff,
+			}
+			if !seen[entry] {
+				seen[entry] = true
+				palette = append(palette, entry)
+			}
+		}
+	}
+
+	dst := image.NewPaletted(src.Bounds(), palette)
+	draw.FloydSteinberg.Draw(dst, dst.Bounds(), src, image.Point{})
+
+	var sum float64
+	for y := 0; y < src.Rect.Dy(); y++ {
+		for x := 0; x < src.Rect.Dx(); x++ {
+			want := src.NRGBAAt(x, y)
+			got := color.NRGBAModel.Convert(dst.At(x, y)).(color.NRGBA)
+			for _, d := range []float64{
+				float64(want.R) - float64(got.R),
+				float64(want.G) - float64(got.G),
+				float64(want.B) - float64(got.B),
+				float64(want.A) - float64(got.A),
+			} {
+				sum += d * d
+			}
+		}
+	}
+	// Pix holds one byte per channel, so its length is the sample count
+	rmse := math.Sqrt(sum / float64(len(src.Pix)))
+	return dst, rmse <= maxError
+}
+
+// optimizeJPEG re-encodes a JPEG at quality, carrying over its
+// APP and comment segments
+func optimizeJPEG(data []byte, quality int) ([]byte, error) {
+	segments, _, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG image: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	for _, s := range segments {
+		if s.Marker >= 0xE0 && s.Marker <= 0xEF || s.Marker == 0xFE {
+			out.Write(data[s.Start:s.End])
+		}
+	}
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes(), nil
+}
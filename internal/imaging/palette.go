@@ -0,0 +1,216 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+const (
+	// longest side of the thumbnail colors are sampled from
+	paletteSampleSize = 64
+	// clusters the sampled colors are grouped into
+	paletteClusters = 8
+	// colors kept in the stored palette
+	paletteSize = 5
+	// upper bound on k-means rounds, which usually settle sooner
+	paletteIterations = 10
+	// clusters whose centers are closer than this are reported as one color
+	paletteMergeDistance = 24
+)
+
+// dominantColors groups the opaque pixels of img with k-means, seeded
+// with a median cut so the result is deterministic, and returns the
+// largest clusters with the share of pixels each of them holds
+func dominantColors(img image.Image) []model.PaletteColor {
+	b := img.Bounds()
+	w, h := fitInside(b.Dx(), b.Dy(), paletteSampleSize)
+	thumb := resize(img, b, w, h)
+
+	pixels := make([][3]float64, 0, w*h)
+	for i := 0; i < len(thumb.Pix); i += 4 {
+		if thumb.Pix[i+3] < 128 {
+			continue
+		}
+		pixels = append(pixels, [3]float64{float64(thumb.Pix[i]), float64(thumb.Pix[i+1]), float64(thumb.Pix[i+2])})
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	centers := medianCut(pixels, paletteClusters)
+	assignment := make([]int, len(pixels))
+	counts := make([]int, len(centers))
+	for iter := 0; iter < paletteIterations; iter++ {
+		changed := false
+		for i, p := range pixels {
+			nearest := nearestCenter(centers, p)
+			if iter == 0 || nearest != assignment[i] {
+				assignment[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][3]float64, len(centers))
+		counts = make([]int, len(centers))
+		for i, p := range pixels {
+			c := assignment[i]
+			counts[c]++
+			for ch := 0; ch < 3; ch++ {
+				sums[c][ch] += p[ch]
+			}
+		}
+		for c := range centers {
+			if counts[c] == 0 {
+				continue
+			}
+			for ch := 0; ch < 3; ch++ {
+				centers[c][ch] = sums[c][ch] / float64(counts[c])
+			}
+		}
+	}
+
+	type cluster struct {
+		center [3]float64
+		count  int
+	}
+	var clusters []cluster
+	for c, center := range centers {
+		if counts[c] == 0 {
+			continue
+		}
+
+		merged := false
+		for i := range clusters {
+			if rgbDistance(clusters[i].center, center) < paletteMergeDistance {
+				total := float64(clusters[i].count + counts[c])
+				for ch := 0; ch < 3; ch++ {
+					clusters[i].center[ch] = (clusters[i].center[ch]*float64(clusters[i].count) + center[ch]*float64(counts[c])) / total
+				}
+				clusters[i].count += counts[c]
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			clusters = append(clusters, cluster{center: center, count: counts[c]})
+		}
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].count > clusters[j].count
+	})
+	if len(clusters) > paletteSize {
+		clusters = clusters[:paletteSize]
+	}
+
+	palette := make([]model.PaletteColor, len(clusters))
+	for i, c := range clusters {
+		palette[i] = model.PaletteColor{
+			Color:  formatColor(c.center),
+			Weight: math.Round(float64(c.count)/float64(len(pixels))*1000) / 1000,
+		}
+	}
+	return palette
+}
+
+// medianCut splits pixels into up to n boxes, each time halving the box
+// with the widest channel at its median, and returns the box averages
+func medianCut(pixels [][3]float64, n int) [][3]float64 {
+	boxes := [][][3]float64{append([][3]float64{}, pixels...)}
+	for len(boxes) < n {
+		widest, channel, widestRange := -1, 0, 0.0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for ch := 0; ch < 3; ch++ {
+				lo, hi := box[0][ch], box[0][ch]
+				for _, p := range box {
+					lo, hi = math.Min(lo, p[ch]), math.Max(hi, p[ch])
+				}
+				if hi-lo > widestRange {
+					widest, channel, widestRange = i, ch, hi-lo
+				}
+			}
+		}
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool { return box[i][channel] < box[j][channel] })
+		boxes[widest] = box[:len(box)/2]
+		boxes = append(boxes, box[len(box)/2:])
+	}
+
+	centers := make([][3]float64, len(boxes))
+	for i, box := range boxes {
+		for _, p := range box {
+			for ch := 0; ch < 3; ch++ {
+				centers[i][ch] += p[ch]
+			}
+		}
+		for ch := 0; ch < 3; ch++ {
+			centers[i][ch] /= float64(len(box))
+		}
+	}
+	return centers
+}
+
+func nearestCenter(centers [][3]float64, p [3]float64) int {
+	nearest, best := 0, math.Inf(1)
+	for i, c := range centers {
+		if d := rgbDistance(c, p); d < best {
+			nearest, best = i, d
+		}
+	}
+	return nearest
+}
+
+func rgbDistance(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+func formatColor(c [3]float64) string {
+	return fmt.Sprintf("#%02x%02x%02x", uint8(math.Round(c[0])), uint8(math.Round(c[1])), uint8(math.Round(c[2])))
+}
+
+// ParseColor reads a color written as six hex digits, with or without a leading #
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color: %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color: %q", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// PaletteMatches reports whether any color of palette lies within
+// tolerance of c, measured as the Euclidean distance in RGB space
+func PaletteMatches(palette []model.PaletteColor, c color.RGBA, tolerance float64) bool {
+	target := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+	for _, entry := range palette {
+		pc, err := ParseColor(entry.Color)
+		if err != nil {
+			continue
+		}
+		if rgbDistance([3]float64{float64(pc.R), float64(pc.G), float64(pc.B)}, target) <= tolerance {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	xdraw "golang.org/x/image/draw"
+)
+
+// size of the thumbnail the DCT of a pHash is taken over
+const pHashSize = 32
+
+// dctCos[u][x] holds the DCT-II basis cos((2x+1)uπ/2N) for N = pHashSize
+var dctCos = func() [pHashSize][pHashSize]float64 {
+	var table [pHashSize][pHashSize]float64
+	for u := 0; u < pHashSize; u++ {
+		for x := 0; x < pHashSize; x++ {
+			table[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * pHashSize))
+		}
+	}
+	return table
+}()
+
+// perceptualHashes computes the average, difference and DCT
+// hashes of an image, each as 16 hex digits
+func perceptualHashes(img image.Image) *model.PerceptualHashes {
+	return &model.PerceptualHashes{
+		AHash: FormatHash(aHash(img)),
+		DHash: FormatHash(dHash(img)),
+		PHash: FormatHash(pHash(img)),
+	}
+}
+
+// FormatHash renders a 64-bit hash as the hex string stored in ImageInfo
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash reads a hash formatted by FormatHash
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// HammingDistance is the number of bits two hashes differ in
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// aHash sets a bit for every pixel of an 8x8 thumbnail
+// brighter than the thumbnail's mean
+func aHash(img image.Image) uint64 {
+	px := grayThumbnail(img, 8, 8)
+
+	var mean float64
+	for _, v := range px {
+		mean += v
+	}
+	mean /= float64(len(px))
+
+	var hash uint64
+	for _, v := range px {
+		hash <<= 1
+		if v > mean {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// dHash sets a bit for every pixel of a 9x8 thumbnail
+// darker than its right neighbour
+func dHash(img image.Image) uint64 {
+	px := grayThumbnail(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if px[y*9+x] < px[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// pHash takes the DCT of a 32x32 thumbnail and sets a bit for every
+// one of the 8x8 lowest frequencies above their median
+func pHash(img image.Image) uint64 {
+	px := grayThumbnail(img, pHashSize, pHashSize)
+
+	// separable 2D DCT-II: rows first, then the low frequency columns
+	var rows [pHashSize][8]float64
+	for y := 0; y < pHashSize; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < pHashSize; x++ {
+				sum += px[y*pHashSize+x] * dctCos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+
+	coeffs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < pHashSize; y++ {
+				sum += rows[y][u] * dctCos[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	// the DC term is the overall brightness and would skew the median
+	sorted := append([]float64{}, coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for _, c := range coeffs {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// grayThumbnail scales img down to w x h and returns
+// the luminance of each pixel in row-major order
+func grayThumbnail(img image.Image, w, h int) []float64 {
+	thumb := image.NewGray(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(thumb, thumb.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+
+	px := make([]float64, w*h)
+	for i, v := range thumb.Pix {
+		px[i] = float64(v)
+	}
+	return px
+}
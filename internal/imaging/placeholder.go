@@ -0,0 +1,140 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"math"
+	"strings"
+)
+
+const (
+	// longest side of the image BlurHash components are computed over
+	blurHashSampleSize = 32
+	// longest side of the LQIP thumbnail
+	lqipSize = 16
+	// JPEG quality of the LQIP thumbnail
+	lqipQuality = 40
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurHash encodes img as a BlurHash (https://blurha.sh) with four
+// components along its long side and three along the short one
+func blurHash(img image.Image) string {
+	b := img.Bounds()
+	xComponents, yComponents := 4, 3
+	if b.Dy() > b.Dx() {
+		xComponents, yComponents = 3, 4
+	}
+
+	w, h := fitInside(b.Dx(), b.Dy(), blurHashSampleSize)
+	small := resize(img, b, w, h)
+
+	// linear RGB of every pixel, computed once for all components
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := small.Pix[small.PixOffset(x, y):]
+			linear[y*w+x] = [3]float64{sRGBToLinear(p[0]), sRGBToLinear(p[1]), sRGBToLinear(p[2])}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					for c := 0; c < 3; c++ {
+						f[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+
+			scale := normalisation / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	encode83(&hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	var actualMax float64
+	for _, f := range ac {
+		actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+	}
+	quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+	maximumValue := float64(quantisedMax+1) / 166
+	encode83(&hash, quantisedMax, 1)
+
+	encode83(&hash, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		encode83(&hash, quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+
+	return hash.String()
+}
+
+// lqip renders a tiny JPEG thumbnail of img as a data URI
+// that clients can inline as a placeholder
+func lqip(img image.Image) (string, error) {
+	b := img.Bounds()
+	w, h := fitInside(b.Dx(), b.Dy(), lqipSize)
+	thumb := prepareForEncode(resize(img, b, w, h), Format{Name: "jpeg"})
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: lqipQuality}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// fitInside scales w x h down so its longest side is at most size
+func fitInside(w, h, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+func encode83(sb *strings.Builder, value int, length int) {
+	for i := 1; i <= length; i++ {
+		digit := value / int(math.Pow(83, float64(length-i))) % 83
+		sb.WriteByte(base83Chars[digit])
+	}
+}
+
+func sRGBToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	c := math.Max(0, math.Min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v float64, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
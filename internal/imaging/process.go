@@ -0,0 +1,537 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/tiff"
+)
+
+// MetadataPolicy controls which embedded metadata survives an upload
+type MetadataPolicy string
+
+const (
+	// StripAll removes EXIF, XMP and IPTC metadata
+	StripAll MetadataPolicy = "strip-all"
+	// StripGPS removes only the location the image was captured at
+	StripGPS MetadataPolicy = "strip-gps"
+	// KeepMetadata stores the metadata untouched
+	KeepMetadata MetadataPolicy = "keep"
+)
+
+// Valid reports whether p is one of the known policies.
+// The empty policy is treated as KeepMetadata
+func (p MetadataPolicy) Valid() bool {
+	switch p {
+	case "", StripAll, StripGPS, KeepMetadata:
+		return true
+	}
+	return false
+}
+
+// ProcessOptions holds the upload-time processing applied to an image
+type ProcessOptions struct {
+	AutoOrient bool
+	Metadata   MetadataPolicy
+}
+
+// quality used whenever a JPEG has to be re-encoded
+const reencodeQuality = 90
+
+var (
+	xmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopIRB = []byte("Photoshop 3.0\x00")
+)
+
+// Process applies the upload options to an image and returns the bytes
+// to store. Images are only re-encoded when they need rotating
+func Process(data []byte, opts ProcessOptions) ([]byte, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image header: %v", err)
+	}
+
+	orientation := 1
+	if raw := exifPayload(data, format); raw != nil {
+		if exif, err := parseExif(raw); err == nil && exif.Orientation > 1 {
+			orientation = exif.Orientation
+		}
+	}
+
+	// stripping the EXIF block also drops the orientation tag, so the
+	// pixels have to be rotated for the image to keep displaying upright.
+	// WebPs aren't re-encoded and keep an EXIF block of the tag alone
+	rotate := orientation > 1 && (opts.AutoOrient || opts.Metadata == StripAll) && canReencode(format)
+
+	switch format {
+	case "jpeg":
+		return processJPEG(data, opts.Metadata, rotate, orientation)
+	case "png":
+		return processPNG(data, opts.Metadata, rotate, orientation)
+	case "webp":
+		return processWebP(data, opts.Metadata, orientation)
+	case "tiff":
+		return processTIFF(data, opts.Metadata, rotate, orientation)
+	case "gif":
+		return processGIF(data, opts.Metadata)
+	}
+
+	return data, nil
+}
+
+func canReencode(format string) bool {
+	return format == "jpeg" || format == "png" || format == "tiff"
+}
+
+// processJPEG filters the metadata segments of a JPEG. When rotating, the
+// kept segments are spliced into the re-encoded image right after SOI
+func processJPEG(data []byte, policy MetadataPolicy, rotate bool, orientation int) ([]byte, error) {
+	segments, scanStart, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out, metadata bytes.Buffer
+	out.Write(data[:2])
+	for _, s := range segments {
+		keep, isMetadata := filterJPEGSegment(data[s.Start:s.End], s, policy, rotate)
+		switch {
+		case isMetadata && rotate:
+			metadata.Write(keep)
+		case !rotate:
+			out.Write(keep)
+		}
+	}
+
+	if !rotate {
+		out.Write(data[scanStart:])
+		return out.Bytes(), nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, orient(img, orientation), &jpeg.Options{Quality: reencodeQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG image: %v", err)
+	}
+
+	metadata.WriteTo(&out)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes(), nil
+}
+
+// filterJPEGSegment returns what is left of a segment after applying the
+// policy, and whether the segment holds metadata rather than image data
+func filterJPEGSegment(raw []byte, s jpegSegment, policy MetadataPolicy, rotate bool) ([]byte, bool) {
+	switch {
+	case s.Marker == 0xE1 && bytes.HasPrefix(s.Payload, exifHeader):
+		exif := filterExif(s.Payload[len(exifHeader):], policy, rotate)
+		if exif == nil {
+			return nil, true
+		}
+		return jpegSegmentBytes(s.Marker, append(append([]byte{}, exifHeader...), exif...)), true
+	case s.Marker == 0xE1 && bytes.HasPrefix(s.Payload, xmpHeader):
+		if !keepXMP(s.Payload, policy) {
+			return nil, true
+		}
+		return raw, true
+	case s.Marker == 0xED && bytes.HasPrefix(s.Payload, photoshopIRB):
+		if policy == StripAll {
+			return nil, true
+		}
+		return raw, true
+	case s.Marker == 0xE2 && bytes.HasPrefix(s.Payload, iccHeader):
+		return raw, true
+	}
+	return raw, false
+}
+
+func jpegSegmentBytes(marker byte, payload []byte) []byte {
+	b := make([]byte, 4, 4+len(payload))
+	b[0] = 0xFF
+	b[1] = marker
+	binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+// processPNG filters the metadata chunks of a PNG. When rotating, the
+// kept chunks are spliced into the re-encoded image right after IHDR
+func processPNG(data []byte, policy MetadataPolicy, rotate bool, orientation int) ([]byte, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out, metadata bytes.Buffer
+	out.Write(pngSignature)
+	for _, c := range chunks {
+		keep, isMetadata := filterPNGChunk(data[c.Start:c.End], c, policy, rotate)
+		switch {
+		case isMetadata && rotate:
+			metadata.Write(keep)
+		case !rotate:
+			out.Write(keep)
+		}
+	}
+
+	if !rotate {
+		return out.Bytes(), nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, orient(img, orientation)); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG image: %v", err)
+	}
+
+	reencoded, err := pngChunks(encoded.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encoded PNG image: %v", err)
+	}
+
+	// IHDR is always the first chunk
+	ihdrEnd := reencoded[0].End
+	out.Reset()
+	out.Write(encoded.Bytes()[:ihdrEnd])
+	metadata.WriteTo(&out)
+	out.Write(encoded.Bytes()[ihdrEnd:])
+	return out.Bytes(), nil
+}
+
+// filterPNGChunk returns what is left of a chunk after applying the
+// policy, and whether the chunk holds metadata rather than image data
+func filterPNGChunk(raw []byte, c pngChunk, policy MetadataPolicy, rotate bool) ([]byte, bool) {
+	switch c.Type {
+	case "eXIf":
+		exif := filterExif(c.Data, policy, rotate)
+		if exif == nil {
+			return nil, true
+		}
+		return pngChunkBytes(c.Type, exif), true
+	case "tEXt", "zTXt", "iTXt":
+		// ImageMagick stores raw EXIF and IPTC profiles as hex encoded
+		// text, which can't be searched for a location
+		if bytes.HasPrefix(c.Data, []byte("Raw profile type")) && policy == StripGPS {
+			return nil, true
+		}
+		if !keepXMP(c.Data, policy) {
+			return nil, true
+		}
+		return raw, true
+	case "iCCP":
+		return raw, true
+	}
+	return raw, false
+}
+
+func pngChunkBytes(chunkType string, data []byte) []byte {
+	b := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[4:], chunkType)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+// VP8X feature flags describing which optional chunks are present
+const (
+	vp8xICC  = 0x20
+	vp8xEXIF = 0x08
+	vp8xXMP  = 0x04
+)
+
+// processWebP filters the metadata chunks of a WebP. WebP can only be
+// written lossless, which would bloat lossy uploads, so the image isn't
+// rotated and the orientation tag is left in place for viewers to apply,
+// even when the rest of the EXIF block is stripped
+func processWebP(data []byte, policy MetadataPolicy, orientation int) ([]byte, error) {
+	chunks, err := riffChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:12])
+	var flags byte
+	vp8x := -1
+	for _, c := range chunks {
+		raw := data[c.Start:c.End]
+		switch c.FourCC {
+		case "VP8X":
+			vp8x = out.Len()
+			out.Write(raw)
+			continue
+		case "EXIF":
+			exif := filterExif(bytes.TrimPrefix(c.Data, exifHeader), policy, false)
+			if exif == nil && orientation > 1 {
+				exif = orientationExif(orientation)
+			}
+			if exif == nil {
+				continue
+			}
+			flags |= vp8xEXIF
+			out.Write(riffChunkBytes(c.FourCC, exif))
+			continue
+		case "XMP ":
+			if !keepXMP(c.Data, policy) {
+				continue
+			}
+			flags |= vp8xXMP
+		case "ICCP":
+			flags |= vp8xICC
+		}
+		out.Write(raw)
+	}
+
+	b := out.Bytes()
+	if vp8x >= 0 && len(b) > vp8x+8 {
+		b[vp8x+8] = b[vp8x+8]&^(vp8xICC|vp8xEXIF|vp8xXMP) | flags
+	}
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, nil
+}
+
+// orientationExif is an EXIF block holding nothing but the orientation tag
+func orientationExif(orientation int) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	// tag 0x0112, type SHORT, one value, stored in the entry itself
+	b = binary.LittleEndian.AppendUint16(b, 0x0112)
+	b = binary.LittleEndian.AppendUint16(b, 3)
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, uint16(orientation))
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	// no further IFDs
+	return binary.LittleEndian.AppendUint32(b, 0)
+}
+
+func riffChunkBytes(fourCC string, data []byte) []byte {
+	b := make([]byte, 8, 9+len(data))
+	copy(b, fourCC)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// processTIFF re-encodes a TIFF when metadata has to go or the image has
+// to be rotated. A TIFF's tags and its EXIF share one IFD chain, so rather
+// than filtering them the pixels are written out anew with no metadata
+// at all, which strips more than StripGPS asks for
+func processTIFF(data []byte, policy MetadataPolicy, rotate bool, orientation int) ([]byte, error) {
+	if !rotate && (policy == "" || policy == KeepMetadata) {
+		return data, nil
+	}
+
+	img, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode TIFF image: %v", err)
+	}
+	if rotate {
+		img = orient(img, orientation)
+	}
+	var out bytes.Buffer
+	if err := tiff.Encode(&out, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true}); err != nil {
+		return nil, fmt.Errorf("failed to encode TIFF image: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// gifLoopApps are the application extensions that hold a GIF's loop
+// count rather than metadata
+var gifLoopApps = [][]byte{[]byte("NETSCAPE2.0"), []byte("ANIMEXTS1.0")}
+
+// processGIF drops the comment and application extensions of a GIF, such
+// as XMP packets, that the policy doesn't keep. Frames are copied as is
+func processGIF(data []byte, policy MetadataPolicy) ([]byte, error) {
+	if policy == "" || policy == KeepMetadata {
+		return data, nil
+	}
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, fmt.Errorf("missing GIF header")
+	}
+
+	i := 13
+	if packed := data[10]; packed&0x80 != 0 {
+		i += 3 << ((packed & 0x07) + 1)
+	}
+	if i > len(data) {
+		return nil, fmt.Errorf("truncated GIF color table")
+	}
+	var out bytes.Buffer
+	out.Write(data[:i])
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x21:
+			if i+1 >= len(data) {
+				return nil, fmt.Errorf("truncated GIF extension")
+			}
+			end, err := skipSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			if isGIFMetadata(data[start:end]) && !keepXMP(data[start:end], policy) {
+				continue
+			}
+		case 0x2C:
+			if i+10 > len(data) {
+				return nil, fmt.Errorf("truncated GIF image descriptor")
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << ((packed & 0x07) + 1)
+			}
+			end, err := skipSubBlocks(data, i+1)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case 0x3B:
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+		default:
+			return nil, fmt.Errorf("invalid GIF block 0x%02x", data[i])
+		}
+		out.Write(data[start:i])
+	}
+	return nil, fmt.Errorf("truncated GIF data")
+}
+
+// isGIFMetadata reports whether an extension block is a comment or an
+// application extension other than the loop count
+func isGIFMetadata(ext []byte) bool {
+	switch ext[1] {
+	case 0xFE:
+		return true
+	case 0xFF:
+		for _, app := range gifLoopApps {
+			if len(ext) >= 3+len(app) && bytes.Equal(ext[3:3+len(app)], app) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// filterExif applies the policy to a raw EXIF block and returns the
+// block to keep, or nil if it should be dropped entirely
+func filterExif(raw []byte, policy MetadataPolicy, resetOrientation bool) []byte {
+	if policy == StripAll {
+		return nil
+	}
+
+	t, err := newTIFF(append([]byte{}, raw...))
+	if err != nil {
+		// an unreadable block can't be scrubbed, so don't keep it
+		if policy == StripGPS {
+			return nil
+		}
+		return raw
+	}
+
+	ifd0, _, err := t.ifd(t.firstIFD())
+	if err != nil {
+		if policy == StripGPS {
+			return nil
+		}
+		return raw
+	}
+
+	for _, e := range ifd0 {
+		switch {
+		case e.Tag == tagOrientation && resetOrientation && e.Type == 3:
+			t.order.PutUint16(t.b[e.ValueOffset:], 1)
+		case e.Tag == tagGPSIFD && policy == StripGPS:
+			t.scrubIFD(int(t.uint(e)))
+		}
+	}
+
+	return t.b
+}
+
+// scrubIFD zeroes every entry of a directory and its out of line values
+// and marks the directory as empty
+func (t *tiffBlock) scrubIFD(offset int) {
+	entries, _, err := t.ifd(offset)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if t.size(e) > 4 {
+			clear(t.b[e.ValueOffset : e.ValueOffset+t.size(e)])
+		}
+		clear(t.b[e.Offset : e.Offset+12])
+	}
+	t.order.PutUint16(t.b[offset:], 0)
+}
+
+// keepXMP reports whether an XMP packet (or PNG text chunk) survives the
+// policy. Packets are dropped whole under StripGPS if they carry a location
+func keepXMP(packet []byte, policy MetadataPolicy) bool {
+	switch policy {
+	case StripAll:
+		return false
+	case StripGPS:
+		return !bytes.Contains(packet, []byte("GPS"))
+	}
+	return true
+}
+
+// orient returns img transformed so that it displays upright, given
+// its EXIF orientation
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,156 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+// SheetLayout selects how Sheet arranges its images
+type SheetLayout string
+
+const (
+	// SheetContact is a grid of equal cells, each labelled with its key
+	SheetContact SheetLayout = "contact"
+	// SheetSprite leaves out labels and puts each image at the top left
+	// of its cell, for use as a CSS sprite
+	SheetSprite SheetLayout = "sprite"
+)
+
+// Valid reports whether l is a known layout
+func (l SheetLayout) Valid() bool {
+	return l == SheetContact || l == SheetSprite
+}
+
+const (
+	// height of the label row under each cell of a contact sheet
+	sheetLabelHeight = 18
+	// width of a basicfont glyph, used to truncate labels
+	sheetGlyphWidth = 7
+)
+
+// SheetOptions controls a composite sheet. Every image is scaled to fit
+// a CellSize square and laid out Columns to a row, Padding pixels apart,
+// on Background. Format is the format the sheet is written in
+type SheetOptions struct {
+	Layout     SheetLayout
+	Columns    int
+	CellSize   int
+	Padding    int
+	Background color.Color
+	Format     string
+}
+
+// SheetImage is one image placed on a sheet
+type SheetImage struct {
+	Key  string
+	Data []byte
+}
+
+// Size returns the dimensions of the sheet n images make
+func (o SheetOptions) Size(n int) (int, int) {
+	cols := min(o.Columns, n)
+	rows := (n + o.Columns - 1) / o.Columns
+	cellHeight := o.CellSize
+	if o.Layout == SheetContact {
+		cellHeight += sheetLabelHeight
+	}
+	return o.Padding + cols*(o.CellSize+o.Padding), o.Padding + rows*(cellHeight+o.Padding)
+}
+
+// Sheet composites images onto a single sheet and returns it along with
+// where each image landed. Images that can't be decoded are left out
+// and their keys returned as skipped
+func Sheet(images []SheetImage, opts SheetOptions) ([]byte, Format, []model.SheetFrame, []string, error) {
+	out, ok := FormatByName(opts.Format)
+	if !ok || !out.CanEncode() {
+		return nil, Format{}, nil, nil, fmt.Errorf("can't write %s sheets", opts.Format)
+	}
+
+	// check every image decodes before sizing the sheet, without
+	// holding more than one decoded image at a time
+	var placed []SheetImage
+	var skipped []string
+	for _, i := range images {
+		if _, _, err := image.DecodeConfig(bytes.NewReader(i.Data)); err != nil {
+			skipped = append(skipped, i.Key)
+			continue
+		}
+		placed = append(placed, i)
+	}
+	if len(placed) == 0 {
+		return nil, Format{}, nil, skipped, fmt.Errorf("none of the images could be decoded")
+	}
+
+	width, height := opts.Size(len(placed))
+	sheet := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+
+	cellHeight := opts.CellSize
+	if opts.Layout == SheetContact {
+		cellHeight += sheetLabelHeight
+	}
+
+	frames := make([]model.SheetFrame, 0, len(placed))
+	for n, p := range placed {
+		img, _, err := image.Decode(bytes.NewReader(p.Data))
+		if err != nil {
+			return nil, Format{}, nil, nil, fmt.Errorf("failed to decode %s: %v", p.Key, err)
+		}
+		b := img.Bounds()
+		w, h := fitInside(b.Dx(), b.Dy(), opts.CellSize)
+		cellX := opts.Padding + n%opts.Columns*(opts.CellSize+opts.Padding)
+		cellY := opts.Padding + n/opts.Columns*(cellHeight+opts.Padding)
+
+		// sprites hug the top left of their cell, contact sheet thumbnails are centered
+		x, y := cellX, cellY
+		if opts.Layout == SheetContact {
+			x += (opts.CellSize - w) / 2
+			y += (opts.CellSize - h) / 2
+		}
+
+		thumb := resize(img, b, w, h)
+		draw.Draw(sheet, image.Rect(x, y, x+w, y+h), thumb, image.Point{}, draw.Over)
+		frames = append(frames, model.SheetFrame{Key: p.Key, X: x, Y: y, Width: w, Height: h})
+
+		if opts.Layout == SheetContact {
+			label := renderText(sheetLabel(p.Key, opts.CellSize), labelColor(opts.Background))
+			lb := label.Bounds()
+			lx := cellX + (opts.CellSize-lb.Dx())/2
+			ly := cellY + opts.CellSize + (sheetLabelHeight-lb.Dy())/2
+			draw.Draw(sheet, image.Rect(lx, ly, lx+lb.Dx(), ly+lb.Dy()), label, lb.Min, draw.Over)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := out.Encode(&buf, prepareForEncode(sheet, out)); err != nil {
+		return nil, Format{}, nil, nil, fmt.Errorf("failed to encode %s image: %v", out.Name, err)
+	}
+	return buf.Bytes(), out, frames, skipped, nil
+}
+
+// sheetLabel shortens a key to fit under a cell, keeping its
+// end since that's where file names differ
+func sheetLabel(key string, width int) string {
+	chars := width / sheetGlyphWidth
+	if len(key) <= chars {
+		return key
+	}
+	if chars <= 3 {
+		return ""
+	}
+	return "..." + key[len(key)-(chars-3):]
+}
+
+// labelColor picks black or white text, whichever reads better on bg
+func labelColor(bg color.Color) color.Color {
+	r, g, b, _ := bg.RGBA()
+	if (299*r+587*g+114*b)/1000 > 0x7fff {
+		return color.Black
+	}
+	return color.White
+}
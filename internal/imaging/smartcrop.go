@@ -0,0 +1,153 @@
+package imaging
+
+import (
+	"image"
+	"math"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// longest side of the thumbnail saliency is computed over
+	saliencySampleSize = 96
+	// side of the cells local entropy is measured in
+	entropyCellSize = 8
+	// gray levels are bucketed into this many bins for entropy
+	entropyBins = 16
+)
+
+// focusRegion is the cover crop of region for a w x h box centered as
+// closely as possible on a focal point given relative to bounds
+func focusRegion(bounds image.Rectangle, region image.Rectangle, w, h int, focus *model.FocalPoint) image.Rectangle {
+	crop := coverRegion(region, w, h)
+	fx := bounds.Min.X + int(focus.X*float64(bounds.Dx()))
+	fy := bounds.Min.Y + int(focus.Y*float64(bounds.Dy()))
+
+	x := clamp(fx-crop.Dx()/2, region.Min.X, region.Max.X-crop.Dx())
+	y := clamp(fy-crop.Dy()/2, region.Min.Y, region.Max.Y-crop.Dy())
+	return image.Rect(x, y, x+crop.Dx(), y+crop.Dy())
+}
+
+// smartRegion is the cover crop of region for a w x h box that holds the
+// most salient part of img. Saliency is the edge energy of each pixel plus
+// the entropy of the cell around it, so both outlines and texture such as
+// faces and products outweigh flat backgrounds
+func smartRegion(img image.Image, region image.Rectangle, w, h int) image.Rectangle {
+	crop := coverRegion(region, w, h)
+	if crop == region {
+		return crop
+	}
+
+	tw, th := fitInside(region.Dx(), region.Dy(), saliencySampleSize)
+	scale := float64(tw) / float64(region.Dx())
+	thumb := image.NewGray(image.Rect(0, 0, tw, th))
+	xdraw.CatmullRom.Scale(thumb, thumb.Bounds(), img, region, xdraw.Src, nil)
+
+	sum := integral(saliency(thumb), tw)
+	windowSum := func(x, y, ww, wh int) float64 {
+		return sum[(y+wh)*(tw+1)+x+ww] - sum[y*(tw+1)+x+ww] - sum[(y+wh)*(tw+1)+x] + sum[y*(tw+1)+x]
+	}
+
+	// the crop spans the region along one axis, so it only slides along the other
+	ww := clamp(int(math.Round(float64(crop.Dx())*scale)), 1, tw)
+	wh := clamp(int(math.Round(float64(crop.Dy())*scale)), 1, th)
+	steps := max(tw-ww, th-wh)
+	best, bestScore, bestOffCenter := 0, math.Inf(-1), steps
+	for i := 0; i <= steps; i++ {
+		x, y := 0, 0
+		if tw-ww > th-wh {
+			x = i
+		} else {
+			y = i
+		}
+
+		// prefer the window closest to the center among equally salient ones
+		score := windowSum(x, y, ww, wh)
+		offCenter := abs(2*i - steps)
+		if score > bestScore+1e-9 || (math.Abs(score-bestScore) <= 1e-9 && offCenter < bestOffCenter) {
+			best, bestScore, bestOffCenter = i, score, offCenter
+		}
+	}
+
+	offset := int(math.Round(float64(best) / scale))
+	if crop.Dx() < region.Dx() {
+		x := clamp(region.Min.X+offset, region.Min.X, region.Max.X-crop.Dx())
+		return image.Rect(x, region.Min.Y, x+crop.Dx(), region.Max.Y)
+	}
+	y := clamp(region.Min.Y+offset, region.Min.Y, region.Max.Y-crop.Dy())
+	return image.Rect(region.Min.X, y, region.Max.X, y+crop.Dy())
+}
+
+// saliency scores every pixel of img by its Sobel gradient magnitude
+// plus the entropy of its cell, both normalised to [0, 1]
+func saliency(img *image.Gray) []float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	at := func(x, y int) float64 {
+		return float64(img.Pix[clamp(y, 0, h-1)*img.Stride+clamp(x, 0, w-1)])
+	}
+
+	cellsX := (w + entropyCellSize - 1) / entropyCellSize
+	cellsY := (h + entropyCellSize - 1) / entropyCellSize
+	entropy := make([]float64, cellsX*cellsY)
+	for cy := 0; cy < cellsY; cy++ {
+		for cx := 0; cx < cellsX; cx++ {
+			var hist [entropyBins]int
+			n := 0
+			for y := cy * entropyCellSize; y < min(h, (cy+1)*entropyCellSize); y++ {
+				for x := cx * entropyCellSize; x < min(w, (cx+1)*entropyCellSize); x++ {
+					hist[int(at(x, y))*entropyBins/256]++
+					n++
+				}
+			}
+
+			var e float64
+			for _, count := range hist {
+				if count > 0 {
+					p := float64(count) / float64(n)
+					e -= p * math.Log2(p)
+				}
+			}
+			entropy[cy*cellsX+cx] = e / math.Log2(entropyBins)
+		}
+	}
+
+	// largest possible Sobel magnitude for 8-bit input
+	const maxGradient = 4 * 255 * math.Sqrt2
+	scores := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			edge := math.Sqrt(gx*gx+gy*gy) / maxGradient
+			scores[y*w+x] = edge + entropy[(y/entropyCellSize)*cellsX+x/entropyCellSize]
+		}
+	}
+	return scores
+}
+
+// integral returns the summed-area table of a score map w wide,
+// with an extra leading row and column of zeros
+func integral(scores []float64, w int) []float64 {
+	h := len(scores) / w
+	sum := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row float64
+		for x := 0; x < w; x++ {
+			row += scores[y*w+x]
+			sum[(y+1)*(w+1)+x+1] = sum[y*(w+1)+x+1] + row
+		}
+	}
+	return sum
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,330 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+// SVGContentType is the MIME type of SVG images
+const SVGContentType = "image/svg+xml"
+
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+)
+
+// SVGCSP is the Content-Security-Policy SVGs are served with. It keeps
+// a stored SVG opened directly in a browser from running script or
+// loading anything beyond inline styles and embedded images
+const SVGCSP = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox"
+
+// svgDropped are the elements removed with everything inside them: the
+// ones that run script or embed other documents
+var svgDropped = map[string]bool{
+	"script": true, "foreignobject": true, "iframe": true, "embed": true, "object": true,
+	"audio": true, "video": true, "canvas": true, "handler": true, "listener": true,
+}
+
+// safeDataURL matches the embedded images an SVG may keep. SVG data URLs
+// are left out since they could carry script of their own
+var safeDataURL = regexp.MustCompile(`^data:image/(png|jpeg|gif|webp)[;,]`)
+
+// cssURL matches url() references in attributes and style sheets
+var cssURL = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]*)`)
+
+// unlike xml.EscapeText these leave whitespace as it is
+var (
+	svgTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	svgAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// IsSVG reports whether data holds an SVG document, going by
+// the name of its root element
+func IsSVG(data []byte) bool {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return false
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(trimmed))
+	d.Strict = false
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			return false
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local == "svg"
+		}
+	}
+}
+
+// SanitizeSVG rewrites an SVG without anything that could run script or
+// reach outside the document: script and foreign content, event handler
+// attributes, links and references to other documents, processing
+// instructions, comments and DOCTYPEs. Entities other than XML's own are
+// refused rather than expanded
+func SanitizeSVG(data []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	d.Strict = true
+
+	var out, style bytes.Buffer
+	skip := 0 // depth inside a dropped element
+	var open []string
+	root := false
+	for {
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SVG: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 || dropSVGElement(t) {
+				skip++
+				continue
+			}
+			if !root && t.Name.Local != "svg" {
+				return nil, fmt.Errorf("root element is %s, not svg", t.Name.Local)
+			}
+			root = true
+			open = append(open, t.Name.Local)
+
+			out.WriteString("<" + qualifiedName(t.Name))
+			for _, a := range t.Attr {
+				if !keepSVGAttr(a) {
+					continue
+				}
+				out.WriteString(" " + qualifiedName(a.Name) + `="` + svgAttrEscaper.Replace(a.Value) + `"`)
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			// style sheets can pull in other documents too. They are
+			// checked whole, since CDATA sections split them into pieces
+			if open[len(open)-1] == "style" {
+				if !unsafeCSS(style.String()) {
+					out.WriteString(svgTextEscaper.Replace(style.String()))
+				}
+				style.Reset()
+			}
+			open = open[:len(open)-1]
+			out.WriteString("</" + qualifiedName(t.Name) + ">")
+		case xml.CharData:
+			if skip > 0 || len(open) == 0 {
+				continue
+			}
+			if open[len(open)-1] == "style" {
+				style.Write(t)
+				continue
+			}
+			out.WriteString(svgTextEscaper.Replace(string(t)))
+		case xml.ProcInst:
+			if t.Target == "xml" && out.Len() == 0 {
+				out.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+		}
+	}
+
+	if !root {
+		return nil, fmt.Errorf("no svg element found")
+	}
+	return out.Bytes(), nil
+}
+
+func dropSVGElement(t xml.StartElement) bool {
+	// elements of other vocabularies, such as XHTML, can't be vetted
+	if t.Name.Space != "" && t.Name.Space != "svg" {
+		return true
+	}
+	local := strings.ToLower(t.Name.Local)
+	if svgDropped[local] {
+		return true
+	}
+
+	for _, a := range t.Attr {
+		if a.Name.Space == "" && a.Name.Local == "xmlns" && a.Value != svgNamespace {
+			return true
+		}
+		// animations can turn a harmless attribute into a link or handler
+		if (local == "set" || strings.HasPrefix(local, "animate")) && a.Name.Local == "attributeName" {
+			target := strings.ToLower(a.Value)
+			if strings.HasSuffix(target, "href") || strings.HasPrefix(target, "on") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func keepSVGAttr(a xml.Attr) bool {
+	name := strings.ToLower(a.Name.Local)
+	value := strings.ToLower(strings.Join(strings.Fields(unescapeCSS(a.Value)), ""))
+
+	switch a.Name.Space {
+	case "":
+		if name == "xmlns" {
+			return a.Value == svgNamespace
+		}
+	case "xmlns":
+		return a.Value == svgNamespace || a.Value == xlinkNamespace
+	case "xlink":
+	case "xml":
+		return name == "space" || name == "lang"
+	default:
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return false
+	case name == "href":
+		return strings.HasPrefix(a.Value, "#") || safeDataURL.MatchString(a.Value)
+	case strings.Contains(value, "javascript:"), strings.Contains(value, "data:text"):
+		return false
+	}
+	return !unsafeCSS(a.Value)
+}
+
+// unsafeCSS reports whether CSS in s imports a style sheet or refers to
+// anything but a fragment of the same document, once escapes and
+// comments that could disguise either are undone
+func unsafeCSS(s string) bool {
+	css := strings.ToLower(unescapeCSS(s))
+	return strings.Contains(css, "@import") || strings.Contains(css, "image-set(") || externalURL(css)
+}
+
+// unescapeCSS decodes the backslash escapes of CSS, such as \72 for r,
+// and drops comments
+func unescapeCSS(s string) string {
+	if !strings.ContainsAny(s, `\/`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 3
+		case s[i] == '\\' && i+1 < len(s):
+			j := i + 1
+			for j < len(s) && j < i+7 && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				// any other character stands for itself
+				if s[j] != '\n' {
+					b.WriteByte(s[j])
+				}
+				i = j
+				continue
+			}
+			r, _ := strconv.ParseUint(s[i+1:j], 16, 32)
+			if r == 0 || r > 0x10FFFF {
+				r = 0xFFFD
+			}
+			b.WriteRune(rune(r))
+			// a single whitespace ends the escape
+			if j < len(s) && (s[j] == ' ' || s[j] == '\t' || s[j] == '\n') {
+				j++
+			}
+			i = j - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// externalURL reports whether CSS in s refers to anything
+// but a fragment of the same document
+func externalURL(s string) bool {
+	for _, m := range cssURL.FindAllStringSubmatch(s, -1) {
+		if !strings.HasPrefix(strings.TrimSpace(m[1]), "#") {
+			return true
+		}
+	}
+	return false
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// inspectSVG collects what can be known about an SVG without
+// rendering it: its size, from the root's width and height or
+// failing that its viewBox
+func inspectSVG(data []byte) (*model.ImageInfo, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	var root xml.StartElement
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SVG: %v", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+
+	var width, height int
+	var viewBox []string
+	for _, a := range root.Attr {
+		switch a.Name.Local {
+		case "width":
+			width = svgLength(a.Value)
+		case "height":
+			height = svgLength(a.Value)
+		case "viewBox":
+			viewBox = strings.FieldsFunc(a.Value, func(r rune) bool { return r == ' ' || r == ',' })
+		}
+	}
+	if (width == 0 || height == 0) && len(viewBox) == 4 {
+		width, height = svgLength(viewBox[2]), svgLength(viewBox[3])
+	}
+
+	return &model.ImageInfo{
+		Width:       width,
+		Height:      height,
+		Format:      "svg",
+		Size:        int64(len(data)),
+		ContentType: SVGContentType,
+	}, nil
+}
+
+// svgLength reads a length in user units or pixels, and
+// returns 0 for relative lengths such as percentages
+func svgLength(v string) int {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(v), "px"), 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return int(f + 0.5)
+}
@@ -0,0 +1,107 @@
+package imaging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	const open = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`
+
+	tests := []struct {
+		name string
+		svg  string
+		drop []string
+		keep []string
+	}{
+		{
+			name: "escaped url in style attribute",
+			svg:  open + `<rect style="fill:u\72l(https://evil.example/x)"/></svg>`,
+			drop: []string{"evil.example"},
+			keep: []string{"<rect"},
+		},
+		{
+			name: "escaped url split by a comment",
+			svg:  open + `<rect style="fill:ur/**/l(https://evil.example/x)"/></svg>`,
+			drop: []string{"evil.example"},
+		},
+		{
+			name: "escaped import in style sheet",
+			svg:  open + `<style>@\69mport "https://evil.example/x.css";</style></svg>`,
+			drop: []string{"evil.example", "mport"},
+			keep: []string{"<style>"},
+		},
+		{
+			name: "import split across CDATA sections",
+			svg:  open + `<style><![CDATA[@imp]]><![CDATA[ort "https://evil.example/x.css";]]></style></svg>`,
+			drop: []string{"evil.example"},
+		},
+		{
+			name: "set turning an attribute into a link",
+			svg:  open + `<a><set attributeName="href" to="javascript:alert(1)"/><text>x</text></a></svg>`,
+			drop: []string{"<set", "javascript"},
+			keep: []string{"<text>x</text>"},
+		},
+		{
+			name: "animate turning an attribute into an xlink",
+			svg:  open + `<a><animate attributeName="xlink:href" values="javascript:alert(1)"/></a></svg>`,
+			drop: []string{"<animate", "javascript"},
+		},
+		{
+			name: "set turning an attribute into a handler",
+			svg:  open + `<rect><set attributeName="onclick" to="alert(1)"/></rect></svg>`,
+			drop: []string{"<set", "alert"},
+		},
+		{
+			name: "fragment references are kept",
+			svg:  open + `<style>.a{fill:url(#g)}</style><rect style="fill:url(#g)"/></svg>`,
+			keep: []string{".a{fill:url(#g)}", `style="fill:url(#g)"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := SanitizeSVG([]byte(tt.svg))
+			if err != nil {
+				t.Fatalf("SanitizeSVG() error = %v", err)
+			}
+			for _, s := range tt.drop {
+				if strings.Contains(string(out), s) {
+					t.Errorf("SanitizeSVG() = %s, still contains %q", out, s)
+				}
+			}
+			for _, s := range tt.keep {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("SanitizeSVG() = %s, lost %q", out, s)
+				}
+			}
+		})
+	}
+}
+
+func TestUnescapeCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want string
+	}{
+		{"plain", "fill:red", "fill:red"},
+		{"hex escape", `u\72l(`, "url("},
+		{"hex escape ended by a space", `u\72 l(`, "url("},
+		{"six digit escape", `u\000072l(`, "url("},
+		{"escape before a letter", `@\69mport`, "@import"},
+		{"escaped letter", `@\import`, "@import"},
+		{"comment", "@im/* x */port", "@import"},
+		{"unterminated comment", "url(/* x", "url("},
+		{"null escape", `\0`, "�"},
+		{"out of range escape", `\110000`, "�"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unescapeCSS(tt.css); got != tt.want {
+				t.Errorf("unescapeCSS(%q) = %q, want %q", tt.css, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"math"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Fit controls how an image is scaled when both a width and a height
+// are requested
+type Fit string
+
+const (
+	// FitContain scales the image to fit inside the box, keeping its aspect ratio
+	FitContain Fit = "contain"
+	// FitCover scales the image to fill the box and crops the overflow around the center
+	FitCover Fit = "cover"
+	// FitFill stretches the image to the box
+	FitFill Fit = "fill"
+	// FitSmart fills the box like FitCover but keeps the image's focal
+	// point, or else its most salient part, in the crop
+	FitSmart Fit = "smart"
+)
+
+// Valid reports whether f is one of the known fit modes.
+// The empty fit is treated as FitContain
+func (f Fit) Valid() bool {
+	switch f {
+	case "", FitContain, FitCover, FitFill, FitSmart:
+		return true
+	}
+	return false
+}
+
+// Quality is the color treatment of a variant
+type Quality string
+
+const (
+	// QualityColor keeps the image's colors
+	QualityColor Quality = "color"
+	// QualityGray reduces the image to shades of gray
+	QualityGray Quality = "gray"
+	// QualityBitonal reduces the image to black and white
+	QualityBitonal Quality = "bitonal"
+)
+
+// Valid reports whether q is one of the known qualities.
+// The empty quality is treated as QualityColor
+func (q Quality) Valid() bool {
+	switch q {
+	case "", QualityColor, QualityGray, QualityBitonal:
+		return true
+	}
+	return false
+}
+
+// TransformOptions describes a variant of a stored image. Crop is an
+// optional region, relative to the top left of the image, applied before
+// scaling. Format is the output format name and defaults to the source's.
+// The scaled image is then mirrored horizontally if Mirror is set, turned
+// clockwise by Rotate degrees, a multiple of 90, and given its Quality.
+// Watermark, when set, is composited onto the result. Focus is the focal
+// point FitSmart crops around, relative to the whole image
+type TransformOptions struct {
+	Width     int
+	Height    int
+	Fit       Fit
+	Crop      image.Rectangle
+	Format    string
+	Mirror    bool
+	Rotate    int
+	Quality   Quality
+	Watermark *Watermark
+	Focus     *model.FocalPoint
+}
+
+// IsZero reports whether the options leave the image untouched
+func (o TransformOptions) IsZero() bool {
+	return o == TransformOptions{}
+}
+
+// Transform renders a variant of an image. Animated GIFs keep all their
+// frames and timing as long as the output is a GIF too. The variant, whose
+// size may follow from the crop rather than the requested size, has to be
+// within limits, as does an animation before its frames are decoded
+func Transform(data []byte, opts TransformOptions, limits Limits) ([]byte, Format, error) {
+	if IsSVG(data) {
+		return nil, Format{}, apperrors.NewUnsupportedMediaType("SVG images are served as stored and can't be transformed")
+	}
+
+	cfg, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, Format{}, fmt.Errorf("failed to decode image header: %v", err)
+	}
+
+	out, err := outputFormat(name, opts.Format)
+	if err != nil {
+		return nil, Format{}, err
+	}
+
+	var buf bytes.Buffer
+	if name == "gif" && out.Name == "gif" {
+		frames, err := gifFrameCount(data)
+		if err != nil {
+			return nil, Format{}, fmt.Errorf("failed to read GIF frames: %v", err)
+		}
+		if reason := limits.checkAnimation(frames, cfg.Width, cfg.Height); reason != "" {
+			return nil, Format{}, apperrors.NewImageTooLarge(reason)
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, Format{}, fmt.Errorf("failed to decode GIF frames: %v", err)
+		}
+
+		// smart crops of animations are chosen from the first frame
+		poster := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+		if opts.Fit == FitSmart && opts.Focus == nil {
+			draw.Draw(poster, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Over)
+		}
+
+		region, w, h, err := opts.plan(poster)
+		if err != nil {
+			return nil, Format{}, err
+		}
+		if err := opts.checkOutput(limits, len(g.Image), w, h); err != nil {
+			return nil, Format{}, err
+		}
+
+		transformed, err := transformGIF(g, region, w, h, opts)
+		if err != nil {
+			return nil, Format{}, err
+		}
+		if err := gif.EncodeAll(&buf, transformed); err != nil {
+			return nil, Format{}, fmt.Errorf("failed to encode GIF image: %v", err)
+		}
+		return buf.Bytes(), out, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Format{}, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	region, w, h, err := opts.plan(img)
+	if err != nil {
+		return nil, Format{}, err
+	}
+	if err := opts.checkOutput(limits, 1, w, h); err != nil {
+		return nil, Format{}, err
+	}
+
+	variant := opts.finish(resize(img, region, w, h))
+	if err := out.Encode(&buf, prepareForEncode(variant, out)); err != nil {
+		return nil, Format{}, fmt.Errorf("failed to encode %s image: %v", out.Name, err)
+	}
+	return buf.Bytes(), out, nil
+}
+
+// outputFormat picks the format a variant is written in. Sources that
+// can't be encoded fall back to lossless PNG
+func outputFormat(source string, requested string) (Format, error) {
+	if requested != "" {
+		f, ok := FormatByName(requested)
+		if !ok || !f.CanEncode() {
+			return Format{}, apperrors.NewBadRequest(fmt.Sprintf("unsupported output format: %s", requested))
+		}
+		return f, nil
+	}
+
+	if f, ok := FormatByName(source); ok && f.CanEncode() {
+		return f, nil
+	}
+	f, _ := FormatByName("png")
+	return f, nil
+}
+
+// plan works out the source region and output size of a variant of img
+func (o TransformOptions) plan(img image.Image) (image.Rectangle, int, int, error) {
+	bounds := img.Bounds()
+	region := bounds
+	if !o.Crop.Empty() {
+		region = o.Crop.Add(bounds.Min).Intersect(bounds)
+		if region.Empty() {
+			return image.Rectangle{}, 0, 0, apperrors.NewBadRequest("crop region lies outside the image")
+		}
+	}
+
+	rw, rh := float64(region.Dx()), float64(region.Dy())
+	switch {
+	case o.Width == 0 && o.Height == 0:
+		return region, region.Dx(), region.Dy(), nil
+	case o.Height == 0:
+		return region, o.Width, scaled(rh, float64(o.Width)/rw), nil
+	case o.Width == 0:
+		return region, scaled(rw, float64(o.Height)/rh), o.Height, nil
+	}
+
+	switch o.Fit {
+	case FitFill:
+		return region, o.Width, o.Height, nil
+	case FitCover:
+		return coverRegion(region, o.Width, o.Height), o.Width, o.Height, nil
+	case FitSmart:
+		if o.Focus != nil {
+			return focusRegion(bounds, region, o.Width, o.Height, o.Focus), o.Width, o.Height, nil
+		}
+		return smartRegion(img, region, o.Width, o.Height), o.Width, o.Height, nil
+	}
+
+	scale := math.Min(float64(o.Width)/rw, float64(o.Height)/rh)
+	return region, scaled(rw, scale), scaled(rh, scale), nil
+}
+
+// checkOutput refuses a planned variant of the given number of frames
+// that is larger than limits allow, once quarter turns are applied
+func (o TransformOptions) checkOutput(limits Limits, frames, w, h int) error {
+	if (o.Rotate/90)%2 != 0 {
+		w, h = h, w
+	}
+	reason := limits.checkSize(w, h)
+	if reason == "" && frames > 1 {
+		reason = limits.checkAnimation(frames, w, h)
+	}
+	if reason != "" {
+		return apperrors.NewBadRequest("variant is too large: " + reason)
+	}
+	return nil
+}
+
+// finish applies the steps that follow scaling
+func (o TransformOptions) finish(img *image.NRGBA) *image.NRGBA {
+	if o.Mirror || o.Rotate%360 != 0 {
+		img = orient(img, orientationFor(o.Rotate, o.Mirror)).(*image.NRGBA)
+	}
+
+	switch o.Quality {
+	case QualityGray:
+		img = grayscale(img, false)
+	case QualityBitonal:
+		img = grayscale(img, true)
+	}
+
+	if o.Watermark != nil {
+		img = o.Watermark.Apply(img)
+	}
+	return img
+}
+
+// orientationFor is the EXIF orientation that turns an image clockwise
+// by rotate degrees, after mirroring it horizontally if mirror is set
+func orientationFor(rotate int, mirror bool) int {
+	turns := ((rotate/90)%4 + 4) % 4
+	if mirror {
+		return [4]int{2, 7, 4, 5}[turns]
+	}
+	return [4]int{1, 6, 3, 8}[turns]
+}
+
+// grayscale replaces each pixel of img with its luma, or with black or
+// white around the midpoint when bitonal is set. Alpha is kept
+func grayscale(img *image.NRGBA, bitonal bool) *image.NRGBA {
+	dst := image.NewNRGBA(img.Rect)
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])
+		y := uint8(math.Round(0.299*r + 0.587*g + 0.114*b))
+		if bitonal {
+			if y < 128 {
+				y = 0
+			} else {
+				y = 0xff
+			}
+		}
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = y, y, y, img.Pix[i+3]
+	}
+	return dst
+}
+
+// coverRegion shrinks region around its center to the aspect ratio of
+// a w x h box, so that scaling it fills the box exactly
+func coverRegion(region image.Rectangle, w, h int) image.Rectangle {
+	rw, rh := region.Dx(), region.Dy()
+	if rw*h > w*rh {
+		cw := max(1, rh*w/h)
+		x := region.Min.X + (rw-cw)/2
+		return image.Rect(x, region.Min.Y, x+cw, region.Max.Y)
+	}
+
+	ch := max(1, rw*h/w)
+	y := region.Min.Y + (rh-ch)/2
+	return image.Rect(region.Min.X, y, region.Max.X, y+ch)
+}
+
+func scaled(length float64, scale float64) int {
+	return max(1, int(math.Round(length*scale)))
+}
+
+// resize scales region of img to a w x h image
+func resize(img image.Image, region image.Rectangle, w, h int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if region.Dx() == w && region.Dy() == h {
+		draw.Draw(dst, dst.Bounds(), img, region.Min, draw.Src)
+		return dst
+	}
+
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, region, draw.Src, nil)
+	return dst
+}
+
+// prepareForEncode flattens transparent images onto white
+// for formats that have no alpha channel
+func prepareForEncode(img image.Image, f Format) image.Image {
+	if f.Name != "jpeg" {
+		return img
+	}
+
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
@@ -0,0 +1,155 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// Limits bounds the size of images accepted on upload and of the variants
+// rendered from them. The pixel and frame limits guard against decompression
+// bombs, small files that decode to huge bitmaps. MaxAnimationPixels bounds
+// frames×width×height, since every frame of an animation is decoded at
+// once. Zero disables a limit
+type Limits struct {
+	MaxBytes           int64
+	MaxWidth           int
+	MaxHeight          int
+	MaxPixels          int64
+	MaxFrames          int
+	MaxAnimationPixels int64
+}
+
+// checkSize returns why an image of the given size is over the
+// limits, or the empty string if it isn't
+func (l Limits) checkSize(width, height int) string {
+	switch pixels := int64(width) * int64(height); {
+	case l.MaxWidth > 0 && width > l.MaxWidth:
+		return fmt.Sprintf("width %d exceeds the limit of %d", width, l.MaxWidth)
+	case l.MaxHeight > 0 && height > l.MaxHeight:
+		return fmt.Sprintf("height %d exceeds the limit of %d", height, l.MaxHeight)
+	case l.MaxPixels > 0 && pixels > l.MaxPixels:
+		return fmt.Sprintf("%d pixels exceeds the limit of %d", pixels, l.MaxPixels)
+	}
+	return ""
+}
+
+// checkAnimation is checkSize for the frames of an animation together
+func (l Limits) checkAnimation(frames, width, height int) string {
+	switch pixels := int64(frames) * int64(width) * int64(height); {
+	case l.MaxFrames > 0 && frames > l.MaxFrames:
+		return fmt.Sprintf("%d frames exceeds the limit of %d", frames, l.MaxFrames)
+	case l.MaxAnimationPixels > 0 && pixels > l.MaxAnimationPixels:
+		return fmt.Sprintf("%d pixels across all frames exceeds the limit of %d", pixels, l.MaxAnimationPixels)
+	}
+	return ""
+}
+
+// Validate checks an upload by decoding its header. The magic bytes, the
+// decoded format, the declared content type and the extension of every
+// given file name have to agree, and the image has to be within limits.
+// It returns the content type of the image
+func Validate(data []byte, declaredType string, limits Limits, fileNames ...string) (string, error) {
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return "", apperrors.NewPayloadTooLarge(limits.MaxBytes, int64(len(data)))
+	}
+
+	sniffed := DetectContentType(data)
+	if !IsImageContentType(sniffed) {
+		return "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("%s is not an allowed image type", sniffed))
+	}
+
+	// SVGs have no pixels to count, and are sanitized on upload instead
+	if sniffed == SVGContentType {
+		return sniffed, checkDeclaredType(sniffed, declaredType, fileNames)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("could not decode image: %v", err))
+	}
+	if f, _ := FormatByName(format); f.ContentType != sniffed {
+		return "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("image decodes as %s but its content is %s", format, sniffed))
+	}
+
+	if err := checkDeclaredType(sniffed, declaredType, fileNames); err != nil {
+		return "", err
+	}
+
+	if err := limits.checkImage(data, cfg, format); err != nil {
+		return "", err
+	}
+
+	return sniffed, nil
+}
+
+// CheckLimits checks that an image already stored is within limits before
+// it is decoded in full, going by its header and, for GIFs, its frame count.
+// SVGs have no pixels to count and always pass
+func CheckLimits(data []byte, limits Limits) error {
+	if IsSVG(data) {
+		return nil
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return apperrors.NewUnsupportedMediaType(fmt.Sprintf("could not decode image: %v", err))
+	}
+	return limits.checkImage(data, cfg, format)
+}
+
+// checkImage holds an image with the given header to the limits
+func (l Limits) checkImage(data []byte, cfg image.Config, format string) error {
+	if reason := l.checkSize(cfg.Width, cfg.Height); reason != "" {
+		return apperrors.NewImageTooLarge(reason)
+	}
+
+	// frames are counted from the block structure, before anything is decoded
+	if format == "gif" && (l.MaxFrames > 0 || l.MaxAnimationPixels > 0) {
+		frames, err := gifFrameCount(data)
+		if err != nil {
+			return apperrors.NewUnsupportedMediaType(fmt.Sprintf("could not decode image: %v", err))
+		}
+		if reason := l.checkAnimation(frames, cfg.Width, cfg.Height); reason != "" {
+			return apperrors.NewImageTooLarge(reason)
+		}
+	}
+	return nil
+}
+
+// checkDeclaredType checks the declared content type and the
+// extension of every file name against the sniffed type
+func checkDeclaredType(sniffed string, declaredType string, fileNames []string) error {
+	if declaredType != "" {
+		mediaType, _, err := mime.ParseMediaType(declaredType)
+		if err != nil || mediaType != sniffed {
+			return apperrors.NewUnsupportedMediaType(fmt.Sprintf("declared type %s does not match content %s", declaredType, sniffed))
+		}
+	}
+
+	for _, name := range fileNames {
+		ext := strings.ToLower(filepath.Ext(name))
+		if extType := contentTypeByExtension(ext); extType != "" && extType != sniffed {
+			return apperrors.NewUnsupportedMediaType(fmt.Sprintf("extension %s does not match content %s", ext, sniffed))
+		}
+	}
+	return nil
+}
+
+// contentTypeByExtension returns the type an extension implies, or the
+// empty string for extensions that imply nothing about the content
+func contentTypeByExtension(ext string) string {
+	if ext == "" {
+		return ""
+	}
+	if f, ok := FormatByExtension(ext); ok {
+		return f.ContentType
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	return mediaType
+}
@@ -0,0 +1,191 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// WatermarkPosition anchors a watermark to a side or corner of the image
+type WatermarkPosition string
+
+const (
+	TopLeft     WatermarkPosition = "top-left"
+	Top         WatermarkPosition = "top"
+	TopRight    WatermarkPosition = "top-right"
+	Left        WatermarkPosition = "left"
+	Center      WatermarkPosition = "center"
+	Right       WatermarkPosition = "right"
+	BottomLeft  WatermarkPosition = "bottom-left"
+	Bottom      WatermarkPosition = "bottom"
+	BottomRight WatermarkPosition = "bottom-right"
+)
+
+// Valid reports whether p is one of the known positions.
+// The empty position is treated as BottomRight
+func (p WatermarkPosition) Valid() bool {
+	switch p {
+	case "", TopLeft, Top, TopRight, Left, Center, Right, BottomLeft, Bottom, BottomRight:
+		return true
+	}
+	return false
+}
+
+const (
+	defaultWatermarkOpacity = 0.5
+	defaultWatermarkScale   = 0.2
+	// gap between a watermark and the image edge, relative to the image's shorter side
+	watermarkMargin = 0.02
+)
+
+// Watermark is a logo image or a line of text composited onto images.
+// Scale is the watermark's width relative to the image's width, and a
+// tiled watermark repeats across the whole image instead of sitting at
+// Position. Load must be called before the watermark is applied
+type Watermark struct {
+	Image    string            `json:"image,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Color    string            `json:"color,omitempty"`
+	Position WatermarkPosition `json:"position,omitempty"`
+	Opacity  float64           `json:"opacity,omitempty"`
+	Scale    float64           `json:"scale,omitempty"`
+	Tile     bool              `json:"tile,omitempty"`
+
+	mark image.Image
+}
+
+// Load validates the watermark and prepares its mark, reading
+// the logo from disk or rendering the text
+func (w *Watermark) Load() error {
+	if (w.Image == "") == (w.Text == "") {
+		return fmt.Errorf("watermark needs exactly one of image and text")
+	}
+	if !w.Position.Valid() {
+		return fmt.Errorf("unknown watermark position %q", w.Position)
+	}
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return fmt.Errorf("watermark opacity must be between 0 and 1")
+	}
+	if w.Scale < 0 || w.Scale > 1 {
+		return fmt.Errorf("watermark scale must be between 0 and 1")
+	}
+
+	if w.Image != "" {
+		data, err := os.ReadFile(w.Image)
+		if err != nil {
+			return fmt.Errorf("failed to read watermark image: %v", err)
+		}
+		mark, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode watermark image: %v", err)
+		}
+		w.mark = mark
+		return nil
+	}
+
+	textColor := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	if w.Color != "" {
+		c, err := ParseColor(w.Color)
+		if err != nil {
+			return fmt.Errorf("invalid watermark color: %v", err)
+		}
+		textColor = c
+	}
+	w.mark = renderText(w.Text, textColor)
+	return nil
+}
+
+// renderText draws a line of text on a transparent background
+func renderText(text string, c color.Color) image.Image {
+	face := basicfont.Face7x13
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+
+	dst := image.NewNRGBA(image.Rect(0, 0, max(1, width), height))
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	d.DrawString(text)
+	return dst
+}
+
+// Apply returns a copy of img with the watermark composited onto it
+func (w *Watermark) Apply(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	if w.mark == nil {
+		return dst
+	}
+
+	scale := w.Scale
+	if scale == 0 {
+		scale = defaultWatermarkScale
+	}
+	opacity := w.Opacity
+	if opacity == 0 {
+		opacity = defaultWatermarkOpacity
+	}
+
+	mb := w.mark.Bounds()
+	mw := max(1, int(float64(b.Dx())*scale))
+	mh := max(1, mw*mb.Dy()/mb.Dx())
+	mark := resize(w.mark, mb, mw, mh)
+	mask := image.NewUniform(color.Alpha{A: uint8(opacity * 255)})
+
+	if w.Tile {
+		// leave half a mark of space between tiles
+		stepX, stepY := mw+mw/2, mh+mh/2
+		for y := 0; y < b.Dy(); y += stepY {
+			for x := 0; x < b.Dx(); x += stepX {
+				r := image.Rect(x, y, x+mw, y+mh)
+				draw.DrawMask(dst, r, mark, image.Point{}, mask, image.Point{}, draw.Over)
+			}
+		}
+		return dst
+	}
+
+	at := watermarkOrigin(w.Position, dst.Bounds(), mw, mh)
+	draw.DrawMask(dst, image.Rect(at.X, at.Y, at.X+mw, at.Y+mh), mark, image.Point{}, mask, image.Point{}, draw.Over)
+	return dst
+}
+
+// watermarkOrigin is the top left corner of an mw x mh watermark
+// anchored at p inside bounds
+func watermarkOrigin(p WatermarkPosition, bounds image.Rectangle, mw, mh int) image.Point {
+	margin := int(float64(min(bounds.Dx(), bounds.Dy())) * watermarkMargin)
+	left, right := margin, bounds.Dx()-mw-margin
+	top, bottom := margin, bounds.Dy()-mh-margin
+	centerX, centerY := (bounds.Dx()-mw)/2, (bounds.Dy()-mh)/2
+
+	switch p {
+	case TopLeft:
+		return image.Pt(left, top)
+	case Top:
+		return image.Pt(centerX, top)
+	case TopRight:
+		return image.Pt(right, top)
+	case Left:
+		return image.Pt(left, centerY)
+	case Center:
+		return image.Pt(centerX, centerY)
+	case Right:
+		return image.Pt(right, centerY)
+	case BottomLeft:
+		return image.Pt(left, bottom)
+	case Bottom:
+		return image.Pt(centerX, bottom)
+	}
+	return image.Pt(right, bottom)
+}
@@ -101,6 +101,15 @@ func NewConflict(name string, value string) *Error {
 	}
 }
 
+// NewIdempotencyConflict to create a 409 for a request reusing
+// an idempotency key it doesn't match
+func NewIdempotencyConflict(reason string) *Error {
+	return &Error{
+		Type:    Conflict,
+		Message: fmt.Sprintf("Idempotency conflict. Reason: %v", reason),
+	}
+}
+
 // NewInternal for 500 errors and unknown errors
 func NewInternal() *Error {
 	return &Error{
@@ -125,6 +134,15 @@ func NewPayloadTooLarge(maxBodySize int64, contentLength int64) *Error {
 	}
 }
 
+// NewImageTooLarge to create a 413 for images whose
+// decoded dimensions exceed the configured limits
+func NewImageTooLarge(reason string) *Error {
+	return &Error{
+		Type:    PayloadTooLarge,
+		Message: fmt.Sprintf("Image too large. Reason: %v", reason),
+	}
+}
+
 // NewServiceUnavailable to create an error for 503
 func NewServiceUnavailable() *Error {
 	return &Error{
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// FileInfo describes a stored object of any type. ContentType is left
+// out of listings, which don't carry it
+type FileInfo struct {
+	Key          string     `json:"key"`
+	Size         int64      `json:"size"`
+	ContentType  string     `json:"contentType,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+}
+
+// FileList is a page of stored files. NextCursor is set when more
+// files remain, and is passed back to fetch the next page
+type FileList struct {
+	Files      []FileInfo `json:"files"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// ArchiveManifest lists what went into a downloaded archive. Missing
+// are the requested keys that weren't found, and Skipped the keys left
+// out because extracting them would write outside the archive's folder
+type ArchiveManifest struct {
+	CreatedAt time.Time      `json:"createdAt"`
+	Prefix    string         `json:"prefix,omitempty"`
+	Files     []ManifestFile `json:"files"`
+	Missing   []string       `json:"missing,omitempty"`
+	Skipped   []string       `json:"skipped,omitempty"`
+}
+
+// ManifestFile is one file of an archive, with its SHA-256 checksum.
+// Path is where the file sits in the archive
+type ManifestFile struct {
+	Key         string `json:"key"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType,omitempty"`
+	SHA256      string `json:"sha256"`
+}
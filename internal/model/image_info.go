@@ -0,0 +1,271 @@
+package model
+
+import "time"
+
+// ImageInfo holds the decoded properties of a stored image.
+// It is computed once on upload and persisted next to the
+// object so it can be served without re-downloading the image
+type ImageInfo struct {
+	Key           string            `json:"key"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Format        string            `json:"format"`
+	ColorModel    string            `json:"colorModel"`
+	Size          int64             `json:"size"`
+	ContentType   string            `json:"contentType"`
+	FrameCount    int               `json:"frameCount,omitempty"`
+	Animation     *Animation        `json:"animation,omitempty"`
+	Exif          *Exif             `json:"exif,omitempty"`
+	HasICCProfile bool              `json:"hasIccProfile"`
+	Hashes        *PerceptualHashes `json:"hashes,omitempty"`
+	BlurHash      string            `json:"blurHash,omitempty"`
+	LQIP          string            `json:"lqip,omitempty"`
+	Palette       []PaletteColor    `json:"palette,omitempty"`
+	FocalPoint    *FocalPoint       `json:"focalPoint,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	UploadedAt    time.Time         `json:"uploadedAt"`
+}
+
+// ImageSummary is the part of an image's info returned when listing
+// images. BlurHash and LQIP let clients render a placeholder without
+// requesting the image
+type ImageSummary struct {
+	Key         string         `json:"key"`
+	Width       int            `json:"width,omitempty"`
+	Height      int            `json:"height,omitempty"`
+	ContentType string         `json:"contentType,omitempty"`
+	Size        int64          `json:"size,omitempty"`
+	BlurHash    string         `json:"blurHash,omitempty"`
+	LQIP        string         `json:"lqip,omitempty"`
+	Palette     []PaletteColor `json:"palette,omitempty"`
+}
+
+// Summary returns the listing view of the info
+func (i *ImageInfo) Summary() ImageSummary {
+	return ImageSummary{
+		Key:         i.Key,
+		Width:       i.Width,
+		Height:      i.Height,
+		ContentType: i.ContentType,
+		Size:        i.Size,
+		BlurHash:    i.BlurHash,
+		LQIP:        i.LQIP,
+		Palette:     i.Palette,
+	}
+}
+
+// ImageList is a page of images. NextCursor is passed back to
+// fetch the following page and is empty on the last one
+type ImageList struct {
+	Images     []ImageSummary `json:"images"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// PaletteColor is one of an image's dominant colors, as #rrggbb,
+// and the share of the image's pixels closest to it
+type PaletteColor struct {
+	Color  string  `json:"color"`
+	Weight float64 `json:"weight"`
+}
+
+// FocalPoint is the point smart crops keep in frame, as fractions
+// of the image's width and height from its top left corner
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// ImageVariant is one rendered size of an image
+type ImageVariant struct {
+	URL         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+}
+
+// Srcset describes the variants of an image for responsive markup.
+// Srcset and Sizes can be used as the attributes of an img element
+type Srcset struct {
+	Key      string         `json:"key"`
+	Variants []ImageVariant `json:"variants"`
+	Srcset   string         `json:"srcset"`
+	Sizes    string         `json:"sizes"`
+}
+
+// Animation holds the timing of an animated image. A LoopCount of 0
+// loops forever and -1 plays the animation once
+type Animation struct {
+	LoopCount  int `json:"loopCount"`
+	DurationMs int `json:"durationMs"`
+}
+
+// PerceptualHashes holds the 64-bit perceptual hashes of an image as
+// hex strings. Similar images have hashes a small Hamming distance apart
+type PerceptualHashes struct {
+	AHash string `json:"aHash"`
+	DHash string `json:"dHash"`
+	PHash string `json:"pHash"`
+}
+
+// SimilarImage is a stored image found near another by perceptual hash
+type SimilarImage struct {
+	Key      string `json:"key"`
+	Distance int    `json:"distance"`
+}
+
+// UploadResult describes an image that was just stored. NearDuplicates
+// lists stored images that look the same, so clients can link to one of
+// them instead of keeping a second copy
+type UploadResult struct {
+	Key            string         `json:"key"`
+	URL            string         `json:"url"`
+	Info           *ImageInfo     `json:"info,omitempty"`
+	NearDuplicates []SimilarImage `json:"nearDuplicates,omitempty"`
+	Optimization   *Optimization  `json:"optimization,omitempty"`
+	DeepZoom       *DeepZoom      `json:"deepZoom,omitempty"`
+}
+
+// DeepZoom describes the Deep Zoom tile pyramid stored for an image
+type DeepZoom struct {
+	Levels int `json:"levels"`
+	Tiles  int `json:"tiles"`
+}
+
+// Optimization reports how an image was shrunk. Method is "none"
+// when no candidate came out smaller than the original
+type Optimization struct {
+	Method         string `json:"method"`
+	OriginalBytes  int64  `json:"originalBytes"`
+	OptimizedBytes int64  `json:"optimizedBytes"`
+	BytesSaved     int64  `json:"bytesSaved"`
+}
+
+// OptimizedImage is the outcome of optimizing one stored image
+// in a batch. Error is set when the image was skipped
+type OptimizedImage struct {
+	Key string `json:"key"`
+	*Optimization
+	Error string `json:"error,omitempty"`
+}
+
+// OptimizationReport sums up a batch optimization. In a dry run
+// nothing is written back and BytesSaved is what would be saved
+type OptimizationReport struct {
+	Prefix     string           `json:"prefix"`
+	DryRun     bool             `json:"dryRun"`
+	Images     []OptimizedImage `json:"images"`
+	BytesSaved int64            `json:"bytesSaved"`
+}
+
+// ArchiveReport is the outcome of a bulk upload from an archive, entry
+// by entry. Aborted says why the rest of the archive was left unread,
+// when it was
+type ArchiveReport struct {
+	Prefix   string         `json:"prefix"`
+	Uploaded int            `json:"uploaded"`
+	Failed   int            `json:"failed"`
+	Skipped  int            `json:"skipped"`
+	Entries  []ArchiveEntry `json:"entries"`
+	Aborted  string         `json:"aborted,omitempty"`
+}
+
+// ArchiveEntry is what became of one entry of an archive. Status is
+// uploaded, failed or skipped, the latter for entries that aren't
+// regular files or are system clutter such as __MACOSX folders
+type ArchiveEntry struct {
+	Path   string `json:"path"`
+	Key    string `json:"key,omitempty"`
+	Status string `json:"status"`
+	Size   int64  `json:"size,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchResult is the outcome of a batch of operations, one result per
+// operation in the order given. RolledBack is set when an atomic batch
+// failed and the operations that had completed were undone
+type BatchResult struct {
+	Atomic     bool              `json:"atomic"`
+	Succeeded  int               `json:"succeeded"`
+	Failed     int               `json:"failed"`
+	RolledBack bool              `json:"rolledBack,omitempty"`
+	Results    []BatchItemResult `json:"results"`
+}
+
+// BatchItemResult is what became of one operation of a batch. Status is
+// done, failed, rolledBack, completed or skipped. Of the operations of an
+// atomic batch that was rolled back, completed ones had nothing to undo
+// and skipped ones were given up on before running
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Key    string `json:"key"`
+	To     string `json:"to,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Exif holds the subset of EXIF fields we surface to clients
+type Exif struct {
+	Make        string     `json:"make,omitempty"`
+	Model       string     `json:"model,omitempty"`
+	Orientation int        `json:"orientation,omitempty"`
+	CapturedAt  *time.Time `json:"capturedAt,omitempty"`
+	GPS         *GPS       `json:"gps,omitempty"`
+}
+
+// GPS holds the position an image was captured at, in decimal degrees
+type GPS struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+// Sheet describes a composite of several images stored as a new object.
+// Frames gives the rectangle each image occupies, and Skipped lists the
+// keys that couldn't be decoded and were left out
+type Sheet struct {
+	Key     string       `json:"key"`
+	URL     string       `json:"url"`
+	Layout  string       `json:"layout"`
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Frames  []SheetFrame `json:"frames"`
+	Skipped []string     `json:"skipped,omitempty"`
+}
+
+// SheetFrame is where an image landed on a sheet, in pixels
+type SheetFrame struct {
+	Key    string `json:"key"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ImageDiff holds the statistics of comparing two images. Pixels count
+// as changed when a channel differs by more than Tolerance, and Boxes
+// bounds the changed areas, largest first. PSNR is null when the pixels
+// both images cover are identical, since it is infinite
+type ImageDiff struct {
+	Base           string    `json:"base"`
+	Compare        string    `json:"compare"`
+	Width          int       `json:"width"`
+	Height         int       `json:"height"`
+	SizeMismatch   bool      `json:"sizeMismatch"`
+	Tolerance      int       `json:"tolerance"`
+	ChangedPixels  int       `json:"changedPixels"`
+	TotalPixels    int       `json:"totalPixels"`
+	ChangedPercent float64   `json:"changedPercent"`
+	Boxes          []DiffBox `json:"boxes"`
+	PSNR           *float64  `json:"psnr"`
+	SSIM           float64   `json:"ssim"`
+}
+
+// DiffBox is a rectangle of changed pixels, in pixels
+type DiffBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
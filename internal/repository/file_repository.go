@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// FileRepository stores arbitrary objects in the bucket, next to the
+// images. The prefixes reserved for image sidecars are off limits
+type FileRepository interface {
+	GetFile(ctx context.Context, key string) ([]byte, *model.FileInfo, error)
+	OpenFile(ctx context.Context, key string) (io.ReadCloser, *model.FileInfo, error)
+	HeadFile(ctx context.Context, key string) (*model.FileInfo, error)
+	CreateFile(ctx context.Context, key string, data []byte, contentType string) (*model.FileInfo, error)
+	PutFile(ctx context.Context, key string, data []byte, contentType string) (*model.FileInfo, error)
+	DeleteFile(ctx context.Context, key string) error
+	ListFiles(ctx context.Context, prefix string, startAfter string, limit int) ([]model.FileInfo, string, error)
+}
+
+type s3FileRepository struct {
+	s3Client   *s3.Client
+	bucketName string
+	// files and images share keys, so writing a file over an image
+	// has to drop what was derived from the image
+	images *gcImageRepository
+}
+
+func NewFileRepository(s3Client *s3.Client, bucketName string) FileRepository {
+	return &s3FileRepository{
+		s3Client:   s3Client,
+		bucketName: bucketName,
+		images:     &gcImageRepository{s3Client: s3Client, bucketName: bucketName},
+	}
+}
+
+func (r *s3FileRepository) GetFile(ctx context.Context, key string) ([]byte, *model.FileInfo, error) {
+	body, info, err := r.OpenFile(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	info.Size = int64(len(data))
+	return data, info, nil
+}
+
+// OpenFile streams a file rather than reading it into memory.
+// The caller has to close the body
+func (r *s3FileRepository) OpenFile(ctx context.Context, key string) (io.ReadCloser, *model.FileInfo, error) {
+	if IsReserved(key) {
+		return nil, nil, apperrors.NewNotFound("file", key)
+	}
+
+	output, err := r.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &r.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil, apperrors.NewNotFound("file", key)
+		}
+		return nil, nil, fmt.Errorf("failed to retrieve file: %v", err)
+	}
+	return output.Body, &model.FileInfo{
+		Key:          key,
+		Size:         aws.ToInt64(output.ContentLength),
+		ContentType:  aws.ToString(output.ContentType),
+		ETag:         aws.ToString(output.ETag),
+		LastModified: output.LastModified,
+	}, nil
+}
+
+func (r *s3FileRepository) HeadFile(ctx context.Context, key string) (*model.FileInfo, error) {
+	if IsReserved(key) {
+		return nil, apperrors.NewNotFound("file", key)
+	}
+
+	output, err := r.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &r.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, apperrors.NewNotFound("file", key)
+		}
+		return nil, fmt.Errorf("failed to retrieve file info: %v", err)
+	}
+	return &model.FileInfo{
+		Key:          key,
+		Size:         aws.ToInt64(output.ContentLength),
+		ContentType:  aws.ToString(output.ContentType),
+		ETag:         aws.ToString(output.ETag),
+		LastModified: output.LastModified,
+	}, nil
+}
+
+// CreateFile stores a file only if nothing is stored under its key yet,
+// which is a conflict otherwise
+func (r *s3FileRepository) CreateFile(ctx context.Context, key string, data []byte, contentType string) (*model.FileInfo, error) {
+	return r.putFile(ctx, key, data, contentType, aws.String("*"))
+}
+
+func (r *s3FileRepository) PutFile(ctx context.Context, key string, data []byte, contentType string) (*model.FileInfo, error) {
+	return r.putFile(ctx, key, data, contentType, nil)
+}
+
+func (r *s3FileRepository) putFile(ctx context.Context, key string, data []byte, contentType string, ifNoneMatch *string) (*model.FileInfo, error) {
+	if IsReserved(key) {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("key %q is under a reserved prefix", key))
+	}
+
+	uploader := manager.NewUploader(r.s3Client)
+	output, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      &r.bucketName,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+		IfNoneMatch: ifNoneMatch,
+	})
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return nil, apperrors.NewConflict("file", key)
+		}
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+	if err := r.images.deleteDerived(ctx, key); err != nil {
+		return nil, err
+	}
+	return &model.FileInfo{
+		Key:         key,
+		Size:        int64(len(data)),
+		ContentType: contentType,
+		ETag:        aws.ToString(output.ETag),
+	}, nil
+}
+
+func (r *s3FileRepository) DeleteFile(ctx context.Context, key string) error {
+	if IsReserved(key) {
+		return apperrors.NewNotFound("file", key)
+	}
+
+	_, err := r.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &r.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+	return r.images.deleteDerived(ctx, key)
+}
+
+// ListFiles returns up to limit files under prefix that sort after
+// startAfter, and the key to resume from when more remain
+func (r *s3FileRepository) ListFiles(ctx context.Context, prefix string, startAfter string, limit int) ([]model.FileInfo, string, error) {
+	var files []model.FileInfo
+	for {
+		page, err := r.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:     &r.bucketName,
+			Prefix:     &prefix,
+			StartAfter: &startAfter,
+			MaxKeys:    aws.Int32(int32(limit - len(files))),
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list files: %v", err)
+		}
+
+		for _, object := range page.Contents {
+			if object.Key == nil {
+				continue
+			}
+			startAfter = *object.Key
+			if IsReserved(*object.Key) {
+				continue
+			}
+			// listings don't carry the content type, which takes a HEAD per file
+			files = append(files, model.FileInfo{
+				Key:          *object.Key,
+				Size:         aws.ToInt64(object.Size),
+				ETag:         aws.ToString(object.ETag),
+				LastModified: object.LastModified,
+			})
+		}
+
+		truncated := page.IsTruncated != nil && *page.IsTruncated
+		if !truncated {
+			return files, "", nil
+		}
+		if len(files) == limit {
+			return files, startAfter, nil
+		}
+	}
+}
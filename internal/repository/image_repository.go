@@ -3,25 +3,53 @@ package repository
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
-	"net/http"
-	"os"
-	"path/filepath"
+	"net/url"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
 )
 
+// variantPrefix is the reserved prefix under which rendered
+// variants of every image are cached
+const variantPrefix = "_variants/"
+
+// tilePrefix is the reserved prefix under which the Deep
+// Zoom tile pyramids of images are stored
+const tilePrefix = "_tiles/"
+
+// metadataPrefix is the reserved prefix under which the
+// decoded info of every image is stored as a JSON sidecar
+const metadataPrefix = "_meta/"
+
 type ImageRepository interface {
 	GetImage(ctx context.Context, objName string) ([]byte, string, error)
-	PostImage(ctx context.Context, filePath string, objectKey string) (string, error)
-	UpdateImage(ctx context.Context, filePath string, objectKey string) (string, error)
+	GetImageVersion(ctx context.Context, objName string, versionID string) ([]byte, string, error)
+	ImageExists(ctx context.Context, objectKey string) (bool, error)
+	PostImage(ctx context.Context, imageData []byte, objectKey string) (string, error)
+	CreateImage(ctx context.Context, imageData []byte, objectKey string) (string, error)
+	UpdateImage(ctx context.Context, imageData []byte, objectKey string) (string, error)
 	DeleteImage(ctx context.Context, objectKey string) error
+	DeleteImages(ctx context.Context, objectKeys []string) (*ImageDeletion, error)
+	CopyImage(ctx context.Context, sourceKey string, destinationKey string, overwrite bool) error
+	GetImageInfo(ctx context.Context, objectKey string) (*model.ImageInfo, error)
+	PutImageInfo(ctx context.Context, info *model.ImageInfo) error
+	ListImages(ctx context.Context, prefix string) ([]string, error)
+	ListImagesPage(ctx context.Context, prefix string, startAfter string, limit int) ([]string, string, error)
+	GetVariant(ctx context.Context, objectKey string, name string) ([]byte, string, error)
+	PutVariant(ctx context.Context, objectKey string, name string, data []byte) error
+	GetTile(ctx context.Context, objectKey string, name string) ([]byte, string, error)
+	PutTile(ctx context.Context, objectKey string, name string, data []byte) error
 }
 
 type gcImageRepository struct {
@@ -36,22 +64,53 @@ func NewImageRepository(s3Client *s3.Client, bucketName string) ImageRepository
 	}
 }
 func (r *gcImageRepository) GetImage(ctx context.Context, objName string) ([]byte, string, error) {
-	output, err := r.s3Client.GetObject(ctx, &s3.GetObjectInput{
+	return r.getImage(ctx, objName, nil)
+}
+
+// GetImageVersion returns an earlier version of an image, in
+// buckets that have versioning enabled
+func (r *gcImageRepository) GetImageVersion(ctx context.Context, objName string, versionID string) ([]byte, string, error) {
+	return r.getImage(ctx, objName, &versionID)
+}
+
+// ImageExists reports whether anything is stored under objectKey
+func (r *gcImageRepository) ImageExists(ctx context.Context, objectKey string) (bool, error) {
+	_, err := r.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: &r.bucketName,
-		Key:    &objName,
+		Key:    &objectKey,
 	})
 	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check for image: %v", err)
+	}
+	return true, nil
+}
+
+func (r *gcImageRepository) getImage(ctx context.Context, objName string, versionID *string) ([]byte, string, error) {
+	output, err := r.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    &r.bucketName,
+		Key:       &objName,
+		VersionId: versionID,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			if versionID != nil {
+				return nil, "", apperrors.NewNotFound("image version", *versionID)
+			}
+			return nil, "", apperrors.NewNotFound("image", objName)
+		}
 		return nil, "", fmt.Errorf("failed to retrieve file: %v", err)
 	}
 	defer output.Body.Close()
-	fmt.Println(output)
 
 	if output.ContentType == nil {
 		return nil, "", fmt.Errorf("content type is nil")
 	}
 	contentType := *output.ContentType
-	if !isImageContentType(contentType) {
-		return nil, "", fmt.Errorf("invalid file type: %s, expected image", contentType)
+	if !imaging.IsImageContentType(contentType) {
+		return nil, "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("invalid file type: %s, expected image", contentType))
 	}
 
 	// Read the image data into a byte slice
@@ -60,41 +119,78 @@ func (r *gcImageRepository) GetImage(ctx context.Context, objName string) ([]byt
 		return nil, "", fmt.Errorf("failed to read image data: %v", err)
 	}
 
-	saveImageData(imageData, "newimageee")
+	// The stored Content-Type is only as trustworthy as whoever wrote
+	// the object, so check it against the bytes themselves
+	if sniffed := imaging.DetectContentType(imageData); sniffed != contentType {
+		return nil, "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("stored content type %s does not match content %s", contentType, sniffed))
+	}
+
 	return imageData, contentType, nil
 }
 
-func (r *gcImageRepository) PostImage(ctx context.Context, filePath string, objectKey string) (string, error) {
-	file, err := os.Open(filePath)
+func (r *gcImageRepository) PostImage(ctx context.Context, imageData []byte, objectKey string) (string, error) {
+	if IsReserved(objectKey) {
+		return "", apperrors.NewBadRequest(fmt.Sprintf("images can't be stored under %s", objectKey))
+	}
+	contentType := imaging.DetectContentType(imageData)
+
+	uploader := manager.NewUploader(r.s3Client)
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      &r.bucketName,
+		Key:         &objectKey,
+		Body:        bytes.NewReader(imageData),
+		ContentType: &contentType,
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %v", err)
+		return "", fmt.Errorf("failed to upload file: %v", err)
 	}
-	defer file.Close()
 
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return "", fmt.Errorf("failed to read file for content type detection: %v", err)
+	// variants and tiles of an image being overwritten are stale
+	if err := r.deleteVariants(ctx, objectKey); err != nil {
+		return "", err
+	}
+	if err := r.deleteTiles(ctx, objectKey); err != nil {
+		return "", err
 	}
 
-	contentType := http.DetectContentType(buffer)
-	file.Seek(0, io.SeekStart)
+	return fmt.Sprintf("File '%s' uploaded successfully to bucket '%s'", objectKey, r.bucketName), nil
+}
 
-	uploader := manager.NewUploader(r.s3Client)
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+// CreateImage stores an image only if nothing is stored under the key
+// yet. The check is part of the PUT, so of two uploads racing for a
+// key one gets a Conflict
+func (r *gcImageRepository) CreateImage(ctx context.Context, imageData []byte, objectKey string) (string, error) {
+	if IsReserved(objectKey) {
+		return "", apperrors.NewBadRequest(fmt.Sprintf("images can't be stored under %s", objectKey))
+	}
+	contentType := imaging.DetectContentType(imageData)
+
+	_, err := r.s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      &r.bucketName,
 		Key:         &objectKey,
-		Body:        file,
+		Body:        bytes.NewReader(imageData),
 		ContentType: &contentType,
+		IfNoneMatch: aws.String("*"),
 	})
 	if err != nil {
+		if isPreconditionFailed(err) {
+			return "", apperrors.NewConflict("image", objectKey)
+		}
 		return "", fmt.Errorf("failed to upload file: %v", err)
 	}
 
+	// a new image can still find stale variants and tiles of a deleted one
+	if err := r.deleteVariants(ctx, objectKey); err != nil {
+		return "", err
+	}
+	if err := r.deleteTiles(ctx, objectKey); err != nil {
+		return "", err
+	}
+
 	return fmt.Sprintf("File '%s' uploaded successfully to bucket '%s'", objectKey, r.bucketName), nil
 }
 
-func (r *gcImageRepository) UpdateImage(ctx context.Context, filePath string, objectKey string) (string, error) {
+func (r *gcImageRepository) UpdateImage(ctx context.Context, imageData []byte, objectKey string) (string, error) {
 	// Delete the existing image
 	err := r.DeleteImage(ctx, objectKey)
 	if err != nil {
@@ -102,7 +198,7 @@ func (r *gcImageRepository) UpdateImage(ctx context.Context, filePath string, ob
 	}
 
 	// Upload the new image
-	return r.PostImage(ctx, filePath, objectKey)
+	return r.PostImage(ctx, imageData, objectKey)
 }
 
 func (r *gcImageRepository) DeleteImage(ctx context.Context, objectKey string) error {
@@ -113,82 +209,434 @@ func (r *gcImageRepository) DeleteImage(ctx context.Context, objectKey string) e
 	if err != nil {
 		return fmt.Errorf("failed to delete image: %v", err)
 	}
+	return r.deleteDerived(ctx, objectKey)
+}
+
+// maxDeleteKeys is the most keys one multi-object delete accepts
+const maxDeleteKeys = 1000
+
+// maxListKeys is the most keys one listing call returns
+const maxListKeys = 1000
+
+// ImageDeletion is the outcome of deleting many images. Failed holds the
+// images that may still be stored, with the reason. Stale holds images
+// that are gone but whose info, variants or tiles weren't all deleted
+type ImageDeletion struct {
+	Failed map[string]error
+	Stale  map[string]error
+}
+
+// DeleteImages deletes many images with multi-object deletes, along with
+// what was derived from them. Keys of sidecar data aren't deleted and are
+// reported as failed
+func (r *gcImageRepository) DeleteImages(ctx context.Context, objectKeys []string) (*ImageDeletion, error) {
+	result := &ImageDeletion{Failed: map[string]error{}, Stale: map[string]error{}}
+	var deletable []string
+	for _, key := range objectKeys {
+		if IsReserved(key) {
+			result.Failed[key] = apperrors.NewBadRequest(fmt.Sprintf("%s isn't an image", key))
+			continue
+		}
+		deletable = append(deletable, key)
+	}
+
+	for start := 0; start < len(deletable); start += maxDeleteKeys / 2 {
+		chunk := deletable[start:min(start+maxDeleteKeys/2, len(deletable))]
+		// every image goes with its info, so each deleted
+		// object is tracked back to the image it belongs to
+		images := make(map[string]bool, len(chunk))
+		infos := make(map[string]string, len(chunk))
+		objects := make([]types.ObjectIdentifier, 0, 2*len(chunk))
+		for _, key := range chunk {
+			metadataKey := infoKey(key)
+			images[key] = true
+			infos[metadataKey] = key
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)}, types.ObjectIdentifier{Key: aws.String(metadataKey)})
+		}
+
+		output, err := r.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &r.bucketName,
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			for _, key := range chunk {
+				result.Failed[key] = fmt.Errorf("failed to delete images: %v", err)
+			}
+			continue
+		}
+		for _, e := range output.Errors {
+			key := aws.ToString(e.Key)
+			err := fmt.Errorf("failed to delete %s: %s", key, aws.ToString(e.Message))
+			if images[key] {
+				result.Failed[key] = err
+			} else if image, ok := infos[key]; ok {
+				result.Stale[image] = err
+			}
+		}
+	}
+
+	for _, key := range deletable {
+		if _, ok := result.Failed[key]; ok {
+			delete(result.Stale, key)
+			continue
+		}
+		if _, ok := result.Stale[key]; ok {
+			continue
+		}
+		if err := r.deleteVariants(ctx, key); err != nil {
+			result.Stale[key] = err
+			continue
+		}
+		if err := r.deleteTiles(ctx, key); err != nil {
+			result.Stale[key] = err
+		}
+	}
+	return result, nil
+}
+
+// CopyImage copies an image within the bucket, and unless overwriting only
+// if the destination key is free. The copy's info is left to the caller,
+// since it names the key
+func (r *gcImageRepository) CopyImage(ctx context.Context, sourceKey string, destinationKey string, overwrite bool) error {
+	if IsReserved(sourceKey) {
+		return apperrors.NewNotFound("image", sourceKey)
+	}
+	if IsReserved(destinationKey) {
+		return apperrors.NewBadRequest(fmt.Sprintf("images can't be copied to %s", destinationKey))
+	}
+	if !overwrite {
+		// CopyObject can't be made conditional on the destination, so
+		// the image is downloaded and stored with a conditional upload
+		imageData, _, err := r.GetImage(ctx, sourceKey)
+		if err != nil {
+			return err
+		}
+		_, err = r.CreateImage(ctx, imageData, destinationKey)
+		return err
+	}
+
+	source := (&url.URL{Path: r.bucketName + "/" + sourceKey}).EscapedPath()
+	_, err := r.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &r.bucketName,
+		Key:        &destinationKey,
+		CopySource: &source,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return apperrors.NewNotFound("image", sourceKey)
+		}
+		return fmt.Errorf("failed to copy image: %v", err)
+	}
+
+	// whatever was derived from an image the copy replaced is stale
+	if err := r.deleteVariants(ctx, destinationKey); err != nil {
+		return err
+	}
+	return r.deleteTiles(ctx, destinationKey)
+}
+
+// deleteDerived removes what was derived from an image: its
+// info, cached variants and tile pyramid
+func (r *gcImageRepository) deleteDerived(ctx context.Context, objectKey string) error {
+	metadataKey := infoKey(objectKey)
+	_, err := r.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &r.bucketName,
+		Key:    &metadataKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete image info: %v", err)
+	}
+
+	if err := r.deleteVariants(ctx, objectKey); err != nil {
+		return err
+	}
+	return r.deleteTiles(ctx, objectKey)
+}
+
+func (r *gcImageRepository) GetImageInfo(ctx context.Context, objectKey string) (*model.ImageInfo, error) {
+	metadataKey := infoKey(objectKey)
+	output, err := r.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &r.bucketName,
+		Key:    &metadataKey,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, apperrors.NewNotFound("image info", objectKey)
+		}
+		return nil, fmt.Errorf("failed to retrieve image info: %v", err)
+	}
+	defer output.Body.Close()
+
+	var info model.ImageInfo
+	if err := json.NewDecoder(output.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode image info: %v", err)
+	}
+
+	return &info, nil
+}
+
+func (r *gcImageRepository) PutImageInfo(ctx context.Context, info *model.ImageInfo) error {
+	body, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode image info: %v", err)
+	}
+
+	metadataKey := infoKey(info.Key)
+	contentType := "application/json"
+	_, err = r.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &r.bucketName,
+		Key:         &metadataKey,
+		Body:        bytes.NewReader(body),
+		ContentType: &contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload image info: %v", err)
+	}
 
 	return nil
 }
 
-func isImageContentType(contentType string) bool {
-	validImageTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/png":  true,
-		"image/gif":  true,
-		"image/webp": true,
-		"image/bmp":  true,
+// ListImages returns the key of every image under prefix,
+// leaving out the sidecars and cached variants
+func (r *gcImageRepository) ListImages(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(r.s3Client, &s3.ListObjectsV2Input{
+		Bucket: &r.bucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list images: %v", err)
+		}
+		for _, object := range page.Contents {
+			if object.Key == nil || IsReserved(*object.Key) {
+				continue
+			}
+			keys = append(keys, *object.Key)
+		}
 	}
-	return validImageTypes[contentType]
+
+	return keys, nil
 }
 
-func getExtensionFromContentType(contentType string) string {
-	contentTypeToExt := map[string]string{
-		"image/jpeg": ".jpg",
-		"image/png":  ".png",
-		"image/gif":  ".gif",
-		"image/webp": ".webp",
-		"image/bmp":  ".bmp",
+// ListImagesPage returns up to limit image keys under prefix that sort
+// after startAfter, and the key to resume from when more remain. Full pages
+// are always requested, and a page ending inside a reserved prefix resumes
+// past the whole prefix, so sidecars and tiles cost few calls to skip
+func (r *gcImageRepository) ListImagesPage(ctx context.Context, prefix string, startAfter string, limit int) ([]string, string, error) {
+	var keys []string
+	for {
+		page, err := r.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:     &r.bucketName,
+			Prefix:     &prefix,
+			StartAfter: &startAfter,
+			MaxKeys:    aws.Int32(maxListKeys),
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list images: %v", err)
+		}
+
+		truncated := page.IsTruncated != nil && *page.IsTruncated
+		last := ""
+		for _, object := range page.Contents {
+			if object.Key == nil {
+				continue
+			}
+			last = *object.Key
+			if IsReserved(last) {
+				continue
+			}
+			if len(keys) == limit {
+				// a further image means there is another page
+				return keys, keys[limit-1], nil
+			}
+			keys = append(keys, last)
+		}
+
+		if !truncated || last == "" {
+			return keys, "", nil
+		}
+		if len(keys) == limit {
+			return keys, keys[limit-1], nil
+		}
+		startAfter = last
+		if skip := pastReserved(last); skip > startAfter {
+			startAfter = skip
+		}
 	}
-	return contentTypeToExt[contentType]
 }
 
-func saveImageData(imageData []byte, filename string) (string, error) {
-	if len(imageData) == 0 {
-		return "", fmt.Errorf("image data is empty")
+// pastReserved is a key sorting after every key of the reserved prefix
+// key is under, or the empty string if key isn't reserved
+func pastReserved(key string) string {
+	for _, p := range []string{metadataPrefix, variantPrefix, tilePrefix} {
+		if strings.HasPrefix(key, p) {
+			return p + string(utf8.MaxRune)
+		}
 	}
+	return ""
+}
 
-	// Create .temp directory if it doesn't exist
-	tempDir := "C:/Users/imkis/OneDrive/Documents/Visual Studio 2019/go_projects/cloudStorage/temp"
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create temp directory: %v", err)
+// GetVariant returns a cached variant of an image and its content type
+func (r *gcImageRepository) GetVariant(ctx context.Context, objectKey string, name string) ([]byte, string, error) {
+	key := variantKey(objectKey, name)
+	output, err := r.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &r.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", apperrors.NewNotFound("variant", name)
+		}
+		return nil, "", fmt.Errorf("failed to retrieve variant: %v", err)
 	}
+	defer output.Body.Close()
 
-	// Determine the image format (PNG, JPEG, etc.)
-	img, format, err := image.Decode(bytes.NewReader(imageData))
+	data, err := io.ReadAll(output.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode image data: %v", err)
+		return nil, "", fmt.Errorf("failed to read variant: %v", err)
 	}
+	return data, aws.ToString(output.ContentType), nil
+}
 
-	// Normalize the filename extension based on the format
-	ext := strings.ToLower(format)
-	if ext != "jpeg" && ext != "png" && ext != "gif" {
-		return "", fmt.Errorf("unsupported image format: %s", ext)
+// PutVariant caches a rendered variant of an image
+func (r *gcImageRepository) PutVariant(ctx context.Context, objectKey string, name string, data []byte) error {
+	key := variantKey(objectKey, name)
+	contentType := imaging.DetectContentType(data)
+	_, err := r.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &r.bucketName,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload variant: %v", err)
 	}
+	return nil
+}
 
-	if ext == "jpeg" {
-		ext = "jpg"
+// GetTile returns a file of an image's tile pyramid and its content type
+func (r *gcImageRepository) GetTile(ctx context.Context, objectKey string, name string) ([]byte, string, error) {
+	key := tileKey(objectKey, name)
+	output, err := r.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &r.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", apperrors.NewNotFound("tile", name)
+		}
+		return nil, "", fmt.Errorf("failed to retrieve tile: %v", err)
 	}
+	defer output.Body.Close()
 
-	// Generate the full file path
-	filePath := filepath.Join(tempDir, fmt.Sprintf("%s.%s", filename, ext))
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read tile: %v", err)
+	}
+	return data, aws.ToString(output.ContentType), nil
+}
 
-	// Create the file
-	file, err := os.Create(filePath)
+// PutTile stores a file of an image's tile pyramid
+func (r *gcImageRepository) PutTile(ctx context.Context, objectKey string, name string, data []byte) error {
+	key := tileKey(objectKey, name)
+	contentType := imaging.DetectContentType(data)
+	_, err := r.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &r.bucketName,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %v", err)
+		return fmt.Errorf("failed to upload tile: %v", err)
+	}
+	return nil
+}
+
+// deleteVariants removes every cached variant of an image
+func (r *gcImageRepository) deleteVariants(ctx context.Context, objectKey string) error {
+	if err := r.deletePrefix(ctx, variantKey(objectKey, "")); err != nil {
+		return fmt.Errorf("failed to delete variants: %v", err)
 	}
-	defer file.Close()
+	return nil
+}
 
-	// Encode and save the image based on its format
-	switch ext {
-	case "jpg":
-		if err := jpeg.Encode(file, img, nil); err != nil {
-			return "", fmt.Errorf("failed to save JPEG image: %v", err)
+// deleteTiles removes the tile pyramid of an image
+func (r *gcImageRepository) deleteTiles(ctx context.Context, objectKey string) error {
+	if err := r.deletePrefix(ctx, tileKey(objectKey, "")); err != nil {
+		return fmt.Errorf("failed to delete tiles: %v", err)
+	}
+	return nil
+}
+
+// deletePrefix removes every object whose key starts with prefix
+func (r *gcImageRepository) deletePrefix(ctx context.Context, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(r.s3Client, &s3.ListObjectsV2Input{
+		Bucket: &r.bucketName,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
 		}
-	case "png":
-		if err := png.Encode(file, img); err != nil {
-			return "", fmt.Errorf("failed to save PNG image: %v", err)
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+		_, err = r.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &r.bucketName,
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
 		}
-	default:
-		return "", fmt.Errorf("unsupported format: %s", ext)
 	}
+	return nil
+}
+
+// variantKey is the key a named variant of an image is cached under.
+// The image key is escaped into a single path segment, so that the
+// variants of "a" never share a prefix with those of "a/b"
+func variantKey(objectKey string, name string) string {
+	return variantPrefix + url.PathEscape(objectKey) + "/" + name
+}
+
+// tileKey is the key a file of an image's tile pyramid is stored
+// under, with the image key escaped as in variantKey
+func tileKey(objectKey string, name string) string {
+	return tilePrefix + url.PathEscape(objectKey) + "/" + name
+}
+
+// IsReserved reports whether key holds sidecar data rather than an image
+func IsReserved(key string) bool {
+	return strings.HasPrefix(key, metadataPrefix) || strings.HasPrefix(key, variantPrefix) || strings.HasPrefix(key, tilePrefix)
+}
+
+// infoKey is the key of the JSON sidecar holding an image's info
+func infoKey(objectKey string) string {
+	return metadataPrefix + objectKey + ".json"
+}
+
+// isNotFound reports whether an S3 error means the key, or
+// the version of it asked for, does not exist
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	// NoSuchVersion isn't modelled by the SDK, so go by its code
+	var coded interface{ ErrorCode() string }
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound) ||
+		errors.As(err, &coded) && coded.ErrorCode() == "NoSuchVersion"
+}
 
-	return filePath, nil
+// isPreconditionFailed reports whether a conditional write was refused
+// because the key is taken, or because a racing write to it is under way
+func isPreconditionFailed(err error) bool {
+	var coded interface{ ErrorCode() string }
+	return errors.As(err, &coded) && (coded.ErrorCode() == "PreconditionFailed" || coded.ErrorCode() == "ConditionalRequestConflict")
 }
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+const (
+	// maxArchiveEntries caps the entries, directories included, read from one archive
+	maxArchiveEntries = 1000
+	// maxArchiveBytes caps both the size of an archive and what it expands to
+	maxArchiveBytes = 1 << 30
+	// zip entries that claim to expand more than maxCompressionRatio
+	// times, and to more than a megabyte, are refused as likely bombs
+	maxCompressionRatio = 1000
+)
+
+// errArchiveTooLarge stops an archive that expands beyond maxArchiveBytes
+var errArchiveTooLarge = archiveAbort(fmt.Sprintf("archive expands to more than %d bytes", maxArchiveBytes))
+
+// archiveAbort stops reading an archive part way, keeping
+// what was uploaded from it until then
+type archiveAbort string
+
+func (a archiveAbort) Error() string {
+	return string(a)
+}
+
+// archiveEntry is an entry of an archive as it is read. Problem
+// is set for entries found unsafe before reading them
+type archiveEntry struct {
+	name    string
+	size    int64
+	regular bool
+	problem string
+	body    io.Reader
+}
+
+// ImportArchive uploads the images in a zip, tar or gzipped tar archive
+// under prefix, each keyed by its path within the archive. Entries are
+// read one at a time and every one is reported on, whether it was
+// uploaded, failed or was skipped. Guards against paths escaping the
+// prefix and against archives expanding beyond limits apply throughout
+func (s *imageService) ImportArchive(ctx context.Context, archive io.ReaderAt, size int64, prefix string, opts UploadOptions) (*model.ArchiveReport, error) {
+	if strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("error in ImportArchive: %w", apperrors.NewBadRequest("prefix can't start with /"))
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if err := checkImageKey(prefix); err != nil {
+		return nil, fmt.Errorf("error in ImportArchive: %w", err)
+	}
+	if size > maxArchiveBytes {
+		return nil, fmt.Errorf("error in ImportArchive: %w", apperrors.NewPayloadTooLarge(maxArchiveBytes, size))
+	}
+
+	report := &model.ArchiveReport{Prefix: prefix, Entries: []model.ArchiveEntry{}}
+	err := walkArchive(archive, size, func(e archiveEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry, err := s.importEntry(ctx, e, prefix, opts)
+		if err != nil {
+			return err
+		}
+
+		report.Entries = append(report.Entries, entry)
+		switch entry.Status {
+		case "uploaded":
+			report.Uploaded++
+		case "failed":
+			report.Failed++
+		default:
+			report.Skipped++
+		}
+		return nil
+	})
+
+	var abort archiveAbort
+	switch {
+	case errors.As(err, &abort):
+		report.Aborted = abort.Error()
+	case err != nil:
+		return nil, fmt.Errorf("error in ImportArchive: %w", err)
+	}
+	return report, nil
+}
+
+// importEntry uploads one entry of an archive. It only returns an
+// error when the rest of the archive shouldn't be read
+func (s *imageService) importEntry(ctx context.Context, e archiveEntry, prefix string, opts UploadOptions) (model.ArchiveEntry, error) {
+	entry := model.ArchiveEntry{Path: e.name, Size: e.size}
+	fail := func(err error) (model.ArchiveEntry, error) {
+		entry.Status, entry.Error = "failed", err.Error()
+		return entry, nil
+	}
+
+	rel, err := archivePath(e.name)
+	switch {
+	case !e.regular:
+		entry.Status, entry.Error = "skipped", "not a regular file"
+		return entry, nil
+	case err != nil:
+		return fail(err)
+	case isArchiveClutter(rel):
+		entry.Status = "skipped"
+		return entry, nil
+	case e.problem != "":
+		return fail(errors.New(e.problem))
+	}
+
+	limit := s.limits.MaxBytes
+	if limit <= 0 {
+		limit = maxArchiveBytes
+	}
+	if e.size > limit {
+		return fail(apperrors.NewPayloadTooLarge(limit, e.size))
+	}
+	// the size in the header isn't trusted, since it is what bombs lie about
+	data, err := io.ReadAll(io.LimitReader(e.body, limit+1))
+	var abort archiveAbort
+	if errors.As(err, &abort) {
+		return entry, err
+	}
+	if err != nil {
+		return fail(fmt.Errorf("failed to read entry: %v", err))
+	}
+	if int64(len(data)) > limit {
+		return fail(apperrors.NewPayloadTooLarge(limit, int64(len(data))))
+	}
+
+	entry.Key = prefix + rel
+	if err := checkImageKey(entry.Key); err != nil {
+		return fail(err)
+	}
+	if err := s.checkKeyFree(ctx, entry.Key, opts.Overwrite); err != nil {
+		return fail(err)
+	}
+	imageData, optimization, err := s.prepareImage(data, "", entry.Key, rel)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := s.storeUpload(ctx, imageData, optimization, entry.Key, opts.Overwrite); err != nil {
+		return fail(err)
+	}
+	entry.Status = "uploaded"
+	return entry, nil
+}
+
+// walkArchive calls fn with each entry of a zip, tar or gzipped tar
+// archive in turn, telling the format apart by its magic bytes
+func walkArchive(archive io.ReaderAt, size int64, fn func(archiveEntry) error) error {
+	head := make([]byte, 512)
+	n, _ := archive.ReadAt(head, 0)
+	head = head[:n]
+
+	budget := &archiveBudget{left: maxArchiveBytes}
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return walkZip(archive, size, budget, fn)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(io.NewSectionReader(archive, 0, size))
+		if err != nil {
+			return apperrors.NewUnsupportedMediaType(fmt.Sprintf("invalid gzip stream: %v", err))
+		}
+		defer gz.Close()
+		return walkTar(budget.reader(gz), budget, fn)
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return walkTar(budget.reader(io.NewSectionReader(archive, 0, size)), budget, fn)
+	default:
+		return apperrors.NewUnsupportedMediaType("archive must be a zip, tar or gzipped tar")
+	}
+}
+
+func walkZip(archive io.ReaderAt, size int64, budget *archiveBudget, fn func(archiveEntry) error) error {
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		return apperrors.NewUnsupportedMediaType(fmt.Sprintf("invalid zip archive: %v", err))
+	}
+	if len(zr.File) > maxArchiveEntries {
+		return apperrors.NewBadRequest(fmt.Sprintf("archive has %d entries, more than the %d allowed", len(zr.File), maxArchiveEntries))
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		e := archiveEntry{
+			name:    f.Name,
+			size:    int64(f.UncompressedSize64),
+			regular: f.Mode().IsRegular(),
+		}
+		if f.UncompressedSize64 > 1<<20 && f.UncompressedSize64/maxCompressionRatio > f.CompressedSize64 {
+			e.problem = fmt.Sprintf("entry claims to expand more than %d times", maxCompressionRatio)
+		}
+
+		if e.regular && e.problem == "" {
+			body, err := f.Open()
+			if err != nil {
+				e.problem = fmt.Sprintf("failed to open entry: %v", err)
+			} else {
+				e.body = budget.reader(body)
+			}
+			err = fn(e)
+			if body != nil {
+				body.Close()
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, budget *archiveBudget, fn func(archiveEntry) error) error {
+	tr := tar.NewReader(r)
+	for count := 0; ; count++ {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var abort archiveAbort
+		switch {
+		case errors.As(err, &abort):
+			return err
+		case err != nil && count == 0:
+			return apperrors.NewUnsupportedMediaType(fmt.Sprintf("invalid tar archive: %v", err))
+		case err != nil:
+			return archiveAbort(fmt.Sprintf("archive is corrupt after %d entries: %v", count, err))
+		case count == maxArchiveEntries:
+			return archiveAbort(fmt.Sprintf("archive has more than the %d entries allowed", maxArchiveEntries))
+		case hdr.Size > budget.left:
+			// skipping the entry would mean expanding it all the same
+			return errArchiveTooLarge
+		case hdr.Typeflag == tar.TypeDir:
+			continue
+		}
+
+		if err := fn(archiveEntry{
+			name:    hdr.Name,
+			size:    hdr.Size,
+			regular: hdr.Typeflag == tar.TypeReg,
+			body:    tr,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// archivePath is the path of an entry relative to the prefix it is
+// uploaded under. Paths that would land outside it are refused
+func archivePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", apperrors.NewBadRequest("absolute paths aren't allowed in archives")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", apperrors.NewBadRequest("paths leading out of the archive aren't allowed")
+		}
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return "", apperrors.NewBadRequest("paths with control characters aren't allowed")
+	}
+
+	rel := path.Clean(name)
+	if rel == "." {
+		return "", apperrors.NewBadRequest("entry has no name")
+	}
+	return rel, nil
+}
+
+// isArchiveClutter reports whether a path is something archivers
+// add on their own, such as macOS resource forks and Finder files
+func isArchiveClutter(rel string) bool {
+	return strings.HasPrefix(rel, "__MACOSX/") || strings.HasPrefix(path.Base(rel), ".")
+}
+
+// archiveBudget is what is left of the bytes an archive
+// may expand to, shared by all its entries
+type archiveBudget struct {
+	left int64
+}
+
+func (b *archiveBudget) reader(r io.Reader) io.Reader {
+	return &budgetReader{r: r, budget: b}
+}
+
+type budgetReader struct {
+	r      io.Reader
+	budget *archiveBudget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	if r.budget.left <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > r.budget.left {
+		p = p[:r.budget.left]
+	}
+	n, err := r.r.Read(p)
+	r.budget.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,41 @@
+package service
+
+import "testing"
+
+func TestArchivePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{name: "file", entry: "a.png", want: "a.png"},
+		{name: "nested", entry: "dir/sub/a.png", want: "dir/sub/a.png"},
+		{name: "backslashes", entry: `dir\a.png`, want: "dir/a.png"},
+		{name: "redundant segments", entry: "dir/./sub//a.png", want: "dir/sub/a.png"},
+		{name: "dots in names", entry: "dir/..a/a..png", want: "dir/..a/a..png"},
+		{name: "parent", entry: "../a.png", wantErr: true},
+		{name: "parent inside", entry: "dir/../../a.png", wantErr: true},
+		{name: "parent that stays inside", entry: "dir/../a.png", wantErr: true},
+		{name: "windows parent", entry: `dir\..\..\a.png`, wantErr: true},
+		{name: "absolute", entry: "/etc/a.png", wantErr: true},
+		{name: "windows absolute", entry: `\a.png`, wantErr: true},
+		{name: "drive letter", entry: "C:/a.png", wantErr: true},
+		{name: "drive relative", entry: "C:a.png", wantErr: true},
+		{name: "control character", entry: "a\x00.png", wantErr: true},
+		{name: "empty", entry: "", wantErr: true},
+		{name: "current directory", entry: "./", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := archivePath(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("archivePath(%q) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("archivePath(%q) = %q, want %q", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+const (
+	// maxBatchOperations caps the operations of one batch
+	maxBatchOperations = 1000
+	// maxAtomicBatchOperations is lower, since atomic batches hold a copy
+	// of every image they delete or overwrite until they are done
+	maxAtomicBatchOperations = 100
+	// defaultBatchConcurrency and maxBatchConcurrency bound how
+	// many operations of a batch run at once
+	defaultBatchConcurrency = 8
+	maxBatchConcurrency     = 32
+	// limits on the tags of an image
+	maxTags        = 50
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+// BatchOp names the operation of a batch step
+type BatchOp string
+
+const (
+	BatchDelete BatchOp = "delete"
+	BatchCopy   BatchOp = "copy"
+	BatchMove   BatchOp = "move"
+	BatchTag    BatchOp = "tag"
+)
+
+// BatchOperation is one step of a batch. To is where copies and moves go,
+// and an image already there is only replaced when Overwrite is set. Tags
+// are merged into the image's tags, an empty value removing the tag.
+// Deleting an image that doesn't exist succeeds
+type BatchOperation struct {
+	Op        BatchOp           `json:"op"`
+	Key       string            `json:"key"`
+	To        string            `json:"to,omitempty"`
+	Overwrite bool              `json:"overwrite,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// BatchRequest is a batch of operations on distinct images. An Atomic
+// batch either completes every operation or undoes those it completed.
+// Concurrency may be zero for the default
+type BatchRequest struct {
+	Operations  []BatchOperation
+	Atomic      bool
+	Concurrency int
+}
+
+// imageBackup is an image as it was before a batch changed it
+type imageBackup struct {
+	key  string
+	data []byte
+	info *model.ImageInfo
+}
+
+// undoStep reverts one completed step of an operation
+type undoStep func(ctx context.Context) error
+
+// RunBatch runs a batch of operations and reports on each of them. Copies,
+// the copying half of moves and tagging run first, with bounded concurrency.
+// Deletes, and the deletion of moved images' sources, follow in multi-object
+// deletes. An atomic batch stops before deleting anything once an operation
+// has failed, and undoes what it had done in reverse order
+func (s *imageService) RunBatch(ctx context.Context, req BatchRequest) (*model.BatchResult, error) {
+	if err := checkBatch(req); err != nil {
+		return nil, fmt.Errorf("error in RunBatch: %w", err)
+	}
+	concurrency := req.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	ops := req.Operations
+	results := make([]model.BatchItemResult, len(ops))
+	undo := make([][]undoStep, len(ops))
+	var first, deletes []int
+	for i, op := range ops {
+		results[i] = model.BatchItemResult{Index: i, Op: string(op.Op), Key: op.Key, To: op.To}
+		if op.Op == BatchDelete {
+			deletes = append(deletes, i)
+		} else {
+			first = append(first, i)
+		}
+	}
+	fail := func(i int, err error) {
+		results[i].Status, results[i].Error = "failed", err.Error()
+	}
+
+	runConcurrently(concurrency, first, func(i int) {
+		var err error
+		switch ops[i].Op {
+		case BatchCopy, BatchMove:
+			err = s.batchCopy(ctx, ops[i], req.Atomic, &undo[i])
+		case BatchTag:
+			err = s.batchTag(ctx, ops[i], &undo[i])
+		}
+		if err != nil {
+			fail(i, err)
+			return
+		}
+		if ops[i].Op != BatchMove {
+			results[i].Status = "done"
+		}
+	})
+
+	// moves are done once their source is deleted
+	for _, i := range first {
+		if ops[i].Op == BatchMove && results[i].Status == "" {
+			deletes = append(deletes, i)
+		}
+	}
+	if !req.Atomic || !anyFailed(results) {
+		s.batchDelete(ctx, ops, deletes, req.Atomic, concurrency, results, undo, fail)
+	}
+
+	batch := &model.BatchResult{Atomic: req.Atomic}
+	if req.Atomic && anyFailed(results) {
+		s.rollBack(ctx, results, undo)
+		batch.RolledBack = true
+	}
+	for _, r := range results {
+		switch r.Status {
+		case "done", "completed":
+			batch.Succeeded++
+		case "failed":
+			batch.Failed++
+		}
+	}
+	batch.Results = results
+	return batch, nil
+}
+
+// batchCopy copies an image server side, along with its info. In an atomic
+// batch an image it overwrites is backed up first, so it can be put back
+func (s *imageService) batchCopy(ctx context.Context, op BatchOperation, atomic bool, undo *[]undoStep) error {
+	if err := s.checkKeyFree(ctx, op.To, op.Overwrite); err != nil {
+		return err
+	}
+	info, err := s.GetImageInfo(ctx, op.Key)
+	if err != nil {
+		return err
+	}
+
+	var replaced *imageBackup
+	if atomic && op.Overwrite {
+		exists, err := s.imageRepo.ImageExists(ctx, op.To)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if replaced, err = s.backupImage(ctx, op.To); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.imageRepo.CopyImage(ctx, op.Key, op.To, op.Overwrite); err != nil {
+		return err
+	}
+	*undo = append(*undo, func(ctx context.Context) error {
+		if replaced != nil {
+			return s.restoreImage(ctx, replaced)
+		}
+		if err := s.imageRepo.DeleteImage(ctx, op.To); err != nil {
+			return err
+		}
+		s.index.remove(op.To)
+		return nil
+	})
+
+	copied := *info
+	copied.Key = op.To
+	return s.storeImageInfo(ctx, &copied)
+}
+
+// batchTag merges tags into an image's info
+func (s *imageService) batchTag(ctx context.Context, op BatchOperation, undo *[]undoStep) error {
+	info, err := s.GetImageInfo(ctx, op.Key)
+	if err != nil {
+		return err
+	}
+
+	previous := info.Tags
+	tags := make(map[string]string, len(previous)+len(op.Tags))
+	for k, v := range previous {
+		tags[k] = v
+	}
+	for k, v := range op.Tags {
+		if v == "" {
+			delete(tags, k)
+		} else {
+			tags[k] = v
+		}
+	}
+	if len(tags) > maxTags {
+		return apperrors.NewBadRequest(fmt.Sprintf("images can have at most %d tags", maxTags))
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+
+	info.Tags = tags
+	if err := s.storeImageInfo(ctx, info); err != nil {
+		return err
+	}
+	*undo = append(*undo, func(ctx context.Context) error {
+		restored := *info
+		restored.Tags = previous
+		return s.storeImageInfo(ctx, &restored)
+	})
+	return nil
+}
+
+// batchDelete deletes the images of delete operations and the sources of
+// moves. An atomic batch backs them all up first, and deletes nothing if
+// that fails for any of them
+func (s *imageService) batchDelete(ctx context.Context, ops []BatchOperation, indexes []int, atomic bool, concurrency int, results []model.BatchItemResult, undo [][]undoStep, fail func(int, error)) {
+	if len(indexes) == 0 {
+		return
+	}
+
+	backups := make([]*imageBackup, len(ops))
+	if atomic {
+		runConcurrently(concurrency, indexes, func(i int) {
+			exists, err := s.imageRepo.ImageExists(ctx, ops[i].Key)
+			if err == nil && exists {
+				backups[i], err = s.backupImage(ctx, ops[i].Key)
+			}
+			if err != nil {
+				fail(i, fmt.Errorf("failed to back up image: %w", err))
+			}
+		})
+		if anyFailed(results) {
+			return
+		}
+	}
+
+	keys := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		keys = append(keys, ops[i].Key)
+	}
+	deletion, err := s.imageRepo.DeleteImages(ctx, keys)
+	for _, i := range indexes {
+		// err fails every key, deletion.Failed only some of them
+		keyErr := err
+		if keyErr == nil {
+			keyErr = deletion.Failed[ops[i].Key]
+		}
+		if keyErr != nil {
+			if ops[i].Op == BatchMove {
+				keyErr = fmt.Errorf("copied to %s but the source wasn't deleted: %w", ops[i].To, keyErr)
+			}
+			fail(i, keyErr)
+			continue
+		}
+		// the image is gone, so leftovers don't fail the operation
+		if stale := deletion.Stale[ops[i].Key]; stale != nil {
+			log.Printf("Deleted %s but left derived data behind: %v\n", ops[i].Key, stale)
+		}
+
+		s.index.remove(ops[i].Key)
+		results[i].Status = "done"
+		if backup := backups[i]; backup != nil {
+			undo[i] = append(undo[i], func(ctx context.Context) error {
+				return s.restoreImage(ctx, backup)
+			})
+		}
+	}
+}
+
+// rollBack undoes the completed steps of every operation, latest first.
+// Operations that were done but had nothing to undo, such as deleting an
+// image that wasn't there, are marked completed, and those that never ran
+// skipped
+func (s *imageService) rollBack(ctx context.Context, results []model.BatchItemResult, undo [][]undoStep) {
+	// undoing has to happen even if the request that ran the batch is gone
+	ctx = context.WithoutCancel(ctx)
+	for i := len(results) - 1; i >= 0; i-- {
+		var err error
+		for j := len(undo[i]) - 1; j >= 0 && err == nil; j-- {
+			err = undo[i][j](ctx)
+		}
+
+		switch {
+		case err != nil:
+			results[i].Status, results[i].Error = "failed", fmt.Sprintf("rolling back failed: %v", err)
+		case results[i].Status == "failed":
+		case len(undo[i]) > 0:
+			results[i].Status = "rolledBack"
+		case results[i].Status == "done":
+			results[i].Status = "completed"
+		default:
+			results[i].Status = "skipped"
+		}
+	}
+}
+
+func (s *imageService) backupImage(ctx context.Context, key string) (*imageBackup, error) {
+	data, _, err := s.imageRepo.GetImage(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.GetImageInfo(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &imageBackup{key: key, data: data, info: info}, nil
+}
+
+// restoreImage puts back an image that a batch deleted or overwrote. Both
+// took its tiles along, so they are rebuilt from the image, while cached
+// variants are rendered again when next asked for
+func (s *imageService) restoreImage(ctx context.Context, backup *imageBackup) error {
+	if _, err := s.imageRepo.PostImage(ctx, backup.data, backup.key); err != nil {
+		return err
+	}
+	if err := s.storeImageInfo(ctx, backup.info); err != nil {
+		return err
+	}
+	_, err := s.storeDeepZoom(ctx, backup.data, backup.info)
+	return err
+}
+
+// checkBatch validates a batch before any of it runs. Each image may
+// only be named by one operation, so that operations can run in any order
+func checkBatch(req BatchRequest) error {
+	switch {
+	case len(req.Operations) == 0:
+		return apperrors.NewBadRequest("operations are required")
+	case len(req.Operations) > maxBatchOperations:
+		return apperrors.NewBadRequest(fmt.Sprintf("batches hold at most %d operations", maxBatchOperations))
+	case req.Atomic && len(req.Operations) > maxAtomicBatchOperations:
+		return apperrors.NewBadRequest(fmt.Sprintf("atomic batches hold at most %d operations", maxAtomicBatchOperations))
+	case req.Concurrency < 0, req.Concurrency > maxBatchConcurrency:
+		return apperrors.NewBadRequest(fmt.Sprintf("concurrency must be between 1 and %d", maxBatchConcurrency))
+	}
+
+	named := map[string]int{}
+	for i, op := range req.Operations {
+		if err := checkBatchOperation(op); err != nil {
+			return apperrors.NewBadRequest(fmt.Sprintf("operation %d: %s", i, err.Error()))
+		}
+		for _, key := range []string{op.Key, op.To} {
+			if key == "" {
+				continue
+			}
+			if j, ok := named[key]; ok {
+				return apperrors.NewBadRequest(fmt.Sprintf("operations %d and %d both name %q", j, i, key))
+			}
+			named[key] = i
+		}
+	}
+	return nil
+}
+
+func checkBatchOperation(op BatchOperation) error {
+	if op.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+	switch op.Op {
+	case BatchDelete:
+	case BatchCopy, BatchMove:
+		if op.To == "" || op.To == op.Key {
+			return fmt.Errorf("%s needs a to key other than key", op.Op)
+		}
+	case BatchTag:
+		if len(op.Tags) == 0 {
+			return fmt.Errorf("tag needs tags")
+		}
+		for k, v := range op.Tags {
+			if k == "" || len(k) > maxTagKeyLen || len(v) > maxTagValueLen {
+				return fmt.Errorf("tag names must be 1 to %d bytes and values at most %d", maxTagKeyLen, maxTagValueLen)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown op %q, use delete, copy, move or tag", op.Op)
+	}
+	return nil
+}
+
+func anyFailed(results []model.BatchItemResult) bool {
+	for _, r := range results {
+		if r.Status == "failed" {
+			return true
+		}
+	}
+	return false
+}
+
+// runConcurrently calls fn for each of indexes, at most limit at a time
+func runConcurrently(limit int, indexes []int, fn func(i int)) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, limit)
+	for _, i := range indexes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
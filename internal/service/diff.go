@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// DiffRequest compares the image at Base with the one at Compare, each
+// at its current version unless a version ID is given. Compare defaults
+// to Base, so two versions of one key only need the versions set
+type DiffRequest struct {
+	Base           string
+	BaseVersion    string
+	Compare        string
+	CompareVersion string
+	Tolerance      int
+}
+
+// DiffImages compares two stored images and returns a PNG highlighting
+// the changed pixels along with the statistics of the comparison
+func (s *imageService) DiffImages(ctx context.Context, req DiffRequest) ([]byte, *model.ImageDiff, error) {
+	if req.Compare == "" {
+		req.Compare = req.Base
+	}
+	switch {
+	case req.Base == "":
+		return nil, nil, fmt.Errorf("error in DiffImages: %w", apperrors.NewBadRequest("base is required"))
+	case req.Base == req.Compare && req.BaseVersion == req.CompareVersion:
+		return nil, nil, fmt.Errorf("error in DiffImages: %w", apperrors.NewBadRequest("compare a different image or version"))
+	case req.Tolerance < 0 || req.Tolerance > 255:
+		return nil, nil, fmt.Errorf("error in DiffImages: %w", apperrors.NewBadRequest("tolerance must be between 0 and 255"))
+	}
+
+	base, err := s.imageVersion(ctx, req.Base, req.BaseVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in DiffImages: %w", err)
+	}
+	compare, err := s.imageVersion(ctx, req.Compare, req.CompareVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in DiffImages: %w", err)
+	}
+
+	diffImage, diff, err := imaging.Diff(base, compare, req.Tolerance)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in DiffImages: %w", apperrors.NewUnsupportedMediaType(err.Error()))
+	}
+	diff.Base, diff.Compare = describeVersion(req.Base, req.BaseVersion), describeVersion(req.Compare, req.CompareVersion)
+	return diffImage, diff, nil
+}
+
+// imageVersion downloads a version of an image, or its current one
+func (s *imageService) imageVersion(ctx context.Context, objectKey string, versionID string) ([]byte, error) {
+	var data []byte
+	var err error
+	if versionID == "" {
+		data, _, err = s.imageRepo.GetImage(ctx, objectKey)
+	} else {
+		data, _, err = s.imageRepo.GetImageVersion(ctx, objectKey, versionID)
+	}
+	return data, err
+}
+
+// describeVersion names an image version in diff results
+func describeVersion(objectKey string, versionID string) string {
+	if versionID == "" {
+		return objectKey
+	}
+	return objectKey + "?versionId=" + versionID
+}
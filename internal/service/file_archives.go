@@ -0,0 +1,271 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// maxExportFiles caps how many files go in one downloaded archive
+const maxExportFiles = 10000
+
+// ArchiveFormat is the format of a downloaded archive
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+func (f ArchiveFormat) Valid() bool {
+	return f == ArchiveZip || f == ArchiveTarGz
+}
+
+// ContentType is the MIME type archives of the format are served as
+func (f ArchiveFormat) ContentType() string {
+	if f == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// storedTypes are already compressed, so zipping them again would
+// only spend CPU
+var storedTypes = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true,
+	"application/zip": true, "application/gzip": true,
+}
+
+// ExportRequest asks for an archive of either the files listed in Keys,
+// kept at their keys, or every file under Prefix, at their paths below
+// the prefix's folder
+type ExportRequest struct {
+	Keys   []string
+	Prefix string
+	Format ArchiveFormat
+}
+
+// archiveWriter is what the zip and tar.gz writers have in common
+type archiveWriter interface {
+	create(path string, info *model.FileInfo) (io.Writer, error)
+	close() error
+}
+
+// ExportArchive writes an archive of the requested files to w as they are
+// read from the bucket, followed by a manifest.json of their keys, sizes
+// and checksums. Errors before anything is written leave w untouched; a
+// failure part way leaves the archive truncated
+func (s *fileService) ExportArchive(ctx context.Context, req ExportRequest, w io.Writer) error {
+	keys, err := s.exportKeys(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error in ExportArchive: %w", err)
+	}
+
+	var aw archiveWriter
+	if req.Format == ArchiveTarGz {
+		aw = newTarGzWriter(w)
+	} else {
+		aw = &zipWriter{zw: zip.NewWriter(w)}
+	}
+
+	manifest := model.ArchiveManifest{
+		CreatedAt: time.Now().UTC(),
+		Prefix:    req.Prefix,
+		Files:     []model.ManifestFile{},
+	}
+	paths := map[string]bool{}
+	for _, key := range keys {
+		rel := key
+		if len(req.Keys) == 0 {
+			rel = key[strings.LastIndex(req.Prefix, "/")+1:]
+		}
+		// keys that would extract outside the folder, or onto another
+		// file once the path is cleaned, are left out
+		path, err := archivePath(rel)
+		if err != nil || paths[path] {
+			manifest.Skipped = append(manifest.Skipped, key)
+			continue
+		}
+
+		file, err := s.exportFile(ctx, aw, key, path)
+		if apperrors.Status(err) == http.StatusNotFound {
+			// listed files can go between listing and reading them too
+			manifest.Missing = append(manifest.Missing, key)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error in ExportArchive: %w", err)
+		}
+		manifest.Files = append(manifest.Files, *file)
+		paths[path] = true
+	}
+
+	if err := writeManifest(aw, manifest, paths); err != nil {
+		return fmt.Errorf("error in ExportArchive: %w", err)
+	}
+	if err := aw.close(); err != nil {
+		return fmt.Errorf("error in ExportArchive: failed to finish archive: %v", err)
+	}
+	return nil
+}
+
+// exportKeys checks an export request and lists the keys it covers
+func (s *fileService) exportKeys(ctx context.Context, req ExportRequest) ([]string, error) {
+	switch {
+	case !req.Format.Valid():
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("unknown archive format %q, use zip or tar.gz", req.Format))
+	case len(req.Keys) > 0 && req.Prefix != "":
+		return nil, apperrors.NewBadRequest("keys and prefix can't be combined")
+	case len(req.Keys) > maxExportFiles:
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("archives hold at most %d files, got %d", maxExportFiles, len(req.Keys)))
+	case len(req.Keys) > 0:
+		// a key listed twice would be written twice at the same path
+		keys := make([]string, 0, len(req.Keys))
+		seen := make(map[string]bool, len(req.Keys))
+		for _, key := range req.Keys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		return keys, nil
+	}
+
+	var keys []string
+	cursor := ""
+	for {
+		files, next, err := s.fileRepo.ListFiles(ctx, req.Prefix, cursor, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			keys = append(keys, f.Key)
+		}
+		if len(keys) > maxExportFiles {
+			return nil, apperrors.NewBadRequest(fmt.Sprintf("archives hold at most %d files, use a narrower prefix", maxExportFiles))
+		}
+		if next == "" {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// exportFile copies a file into the archive, checksumming it on the way
+func (s *fileService) exportFile(ctx context.Context, aw archiveWriter, key string, path string) (*model.ManifestFile, error) {
+	body, info, err := s.fileRepo.OpenFile(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	entry, err := aw.create(path, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s to archive: %v", key, err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(entry, h), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy %s into archive: %v", key, err)
+	}
+
+	return &model.ManifestFile{
+		Key:         key,
+		Path:        path,
+		Size:        n,
+		ContentType: info.ContentType,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// writeManifest adds the manifest as manifest.json, or under
+// a numbered name if a file already took that path
+func writeManifest(aw archiveWriter, manifest model.ArchiveManifest, paths map[string]bool) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+
+	name := "manifest.json"
+	for i := 1; paths[name]; i++ {
+		name = fmt.Sprintf("manifest-%d.json", i)
+	}
+	entry, err := aw.create(name, &model.FileInfo{
+		Size:         int64(len(data)),
+		ContentType:  "application/json",
+		LastModified: &manifest.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add manifest to archive: %v", err)
+	}
+	if _, err := entry.Write(data); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) create(path string, info *model.FileInfo) (io.Writer, error) {
+	header := &zip.FileHeader{Name: path, Method: zip.Deflate}
+	if storedTypes[info.ContentType] {
+		header.Method = zip.Store
+	}
+	if info.LastModified != nil {
+		header.Modified = *info.LastModified
+	}
+	return z.zw.CreateHeader(header)
+}
+
+func (z *zipWriter) close() error {
+	return z.zw.Close()
+}
+
+// tarGzWriter writes a gzipped tar. Tar headers carry the size up front,
+// so entries rely on the size the bucket reports
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (t *tarGzWriter) create(path string, info *model.FileInfo) (io.Writer, error) {
+	header := &tar.Header{
+		Name:     path,
+		Mode:     0644,
+		Size:     info.Size,
+		Typeflag: tar.TypeReg,
+	}
+	if info.LastModified != nil {
+		header.ModTime = *info.LastModified
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	return t.tw, nil
+}
+
+func (t *tarGzWriter) close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// FilePolicy configures what may be stored through the file API under
+// keys starting with Prefix. The longest matching prefix wins.
+// AllowedTypes lists MIME types, or "type/*" wildcards, and allows
+// anything when empty. MaxBytes overrides the default size limit, and
+// Hooks names the processing run on each file, in order, before it
+// is stored
+type FilePolicy struct {
+	Prefix       string   `json:"prefix"`
+	AllowedTypes []string `json:"allowedTypes,omitempty"`
+	MaxBytes     int64    `json:"maxBytes,omitempty"`
+	Hooks        []string `json:"hooks,omitempty"`
+}
+
+// Valid reports whether the policy names only known hooks
+// and well-formed MIME types
+func (p FilePolicy) Valid() bool {
+	if p.MaxBytes < 0 {
+		return false
+	}
+	for _, t := range p.AllowedTypes {
+		if _, _, err := mime.ParseMediaType(t); err != nil && !strings.HasSuffix(t, "/*") {
+			return false
+		}
+	}
+	for _, name := range p.Hooks {
+		if _, ok := fileHooks[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// allows reports whether the policy accepts files of contentType
+func (p FilePolicy) allows(contentType string) bool {
+	if len(p.AllowedTypes) == 0 {
+		return true
+	}
+	for _, t := range p.AllowedTypes {
+		if canonicalType(t) == contentType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileHook checks, and may rewrite, a file before it is stored
+type fileHook func(data []byte) ([]byte, error)
+
+// fileHooks are the processing hooks policies can name
+var fileHooks = map[string]fileHook{
+	"validate-pdf": validatePDF,
+	"validate-csv": validateCSV,
+	"validate-zip": validateZip,
+	"strip-bom":    stripBOM,
+}
+
+func validatePDF(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, apperrors.NewUnsupportedMediaType("file is not a PDF")
+	}
+	// a complete PDF ends with an end-of-file marker, give or take trailing whitespace
+	if !bytes.Contains(data[max(0, len(data)-1024):], []byte("%%EOF")) {
+		return nil, apperrors.NewBadRequest("PDF is truncated")
+	}
+	return data, nil
+}
+
+// validateCSV checks that every record of a CSV parses and has
+// as many fields as the first
+func validateCSV(data []byte) ([]byte, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.ReuseRecord = true
+	for {
+		_, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return data, nil
+		}
+		if err != nil {
+			return nil, apperrors.NewBadRequest(fmt.Sprintf("invalid CSV: %v", err))
+		}
+	}
+}
+
+// validateZip checks that a zip archive's directory reads and that
+// none of its entries would land outside the folder it is unpacked in
+func validateZip(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("invalid zip archive: %v", err))
+	}
+	for _, f := range zr.File {
+		name := strings.ReplaceAll(f.Name, `\`, "/")
+		if strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") || strings.Contains(name, "/../") {
+			return nil, apperrors.NewBadRequest(fmt.Sprintf("zip entry %q escapes the archive", f.Name))
+		}
+	}
+	return data, nil
+}
+
+func stripBOM(data []byte) ([]byte, error) {
+	return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), nil
+}
+
+// filePolicy returns the policy for a key, or the zero policy
+// (any type, default size limit) when no prefix matches
+func (s *fileService) filePolicy(key string) FilePolicy {
+	var match FilePolicy
+	found := false
+	for _, policy := range s.policies {
+		if !strings.HasPrefix(key, policy.Prefix) {
+			continue
+		}
+		if !found || len(policy.Prefix) > len(match.Prefix) {
+			match = policy
+			found = true
+		}
+	}
+	return match
+}
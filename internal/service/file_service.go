@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+	"github.com/imkishore16/go-cloudStorage/internal/repository"
+)
+
+// FileService defines the interface for storing arbitrary files,
+// such as PDFs, CSVs and archives, next to the images
+type FileService interface {
+	GetFile(ctx context.Context, key string) ([]byte, *model.FileInfo, error)
+	GetFileInfo(ctx context.Context, key string) (*model.FileInfo, error)
+	PutFile(ctx context.Context, key string, upload FileUpload) (*model.FileInfo, error)
+	DeleteFile(ctx context.Context, key string) error
+	ListFiles(ctx context.Context, prefix string, cursor string, limit int) (*model.FileList, error)
+	ExportArchive(ctx context.Context, req ExportRequest, w io.Writer) error
+}
+
+// FileUpload is a file as received: its name and declared type, both
+// of which may be empty, and its body of Size bytes. Tenant and
+// Overwrite are as for image uploads
+type FileUpload struct {
+	FileName    string
+	ContentType string
+	Size        int64
+	Body        io.Reader
+	Tenant      string
+	Overwrite   bool
+}
+
+// fileService is the concrete implementation of FileService
+type fileService struct {
+	fileRepo     repository.FileRepository
+	imageService ImageService
+	policies     []FilePolicy
+	maxBytes     int64
+	keyTemplate  KeyTemplate
+}
+
+// FileServiceConfig holds the dependencies and settings used to
+// initialize a FileService. MaxBytes limits the size of files whose
+// policy sets no limit of its own, and zero means no limit. ImageService
+// is told of images that file writes and deletes replace
+type FileServiceConfig struct {
+	FileRepository repository.FileRepository
+	ImageService   ImageService
+	Policies       []FilePolicy
+	MaxBytes       int64
+	KeyTemplate    KeyTemplate
+}
+
+// NewFileService initializes a new FileService
+func NewFileService(c *FileServiceConfig) FileService {
+	return &fileService{
+		fileRepo:     c.FileRepository,
+		imageService: c.ImageService,
+		policies:     c.Policies,
+		maxBytes:     c.MaxBytes,
+		keyTemplate:  c.KeyTemplate,
+	}
+}
+
+// genericTypes are what sniffing reports when it can't tell more, in which
+// case the declared type or the file extension is taken instead
+var genericTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+	// OOXML and OpenDocument files, JARs and the like are all zips
+	"application/zip": true,
+}
+
+func (s *fileService) GetFile(ctx context.Context, key string) ([]byte, *model.FileInfo, error) {
+	data, info, err := s.fileRepo.GetFile(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in GetFile: %w", err)
+	}
+	return data, info, nil
+}
+
+func (s *fileService) GetFileInfo(ctx context.Context, key string) (*model.FileInfo, error) {
+	info, err := s.fileRepo.HeadFile(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("error in GetFileInfo: %w", err)
+	}
+	return info, nil
+}
+
+// PutFile stores a file under key, or a key made from the key template when
+// empty, once it passes the policy for the key: its size and type are
+// checked and its hooks run in order. Images are refused, since the image
+// routes serve the same keys and only images uploaded through them are
+// validated and sanitized
+func (s *fileService) PutFile(ctx context.Context, key string, upload FileUpload) (*model.FileInfo, error) {
+	key, err := s.uploadKey(ctx, key, upload)
+	if err != nil {
+		return nil, fmt.Errorf("error in PutFile: %w", err)
+	}
+
+	policy := s.filePolicy(key)
+	maxBytes := s.maxBytes
+	if policy.MaxBytes > 0 {
+		maxBytes = policy.MaxBytes
+	}
+	if maxBytes > 0 && upload.Size > maxBytes {
+		return nil, fmt.Errorf("error in PutFile: %w", apperrors.NewPayloadTooLarge(maxBytes, upload.Size))
+	}
+
+	body := upload.Body
+	if maxBytes > 0 {
+		body = io.LimitReader(body, maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("error in PutFile: %w", apperrors.NewBadRequest("failed to read file"))
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("error in PutFile: %w", apperrors.NewPayloadTooLarge(maxBytes, int64(len(data))))
+	}
+
+	contentType, err := fileType(data, upload.FileName, upload.ContentType)
+	if err != nil {
+		return nil, fmt.Errorf("error in PutFile: %w", err)
+	}
+	if isImage(data, contentType) {
+		return nil, fmt.Errorf("error in PutFile: %w", apperrors.NewUnsupportedMediaType("images have to be uploaded to /images"))
+	}
+	if !policy.allows(contentType) {
+		return nil, fmt.Errorf("error in PutFile: %w", apperrors.NewUnsupportedMediaType(fmt.Sprintf("%s files can't be stored under %q", contentType, key)))
+	}
+
+	for _, name := range policy.Hooks {
+		if data, err = fileHooks[name](data); err != nil {
+			return nil, fmt.Errorf("error in PutFile: %s: %w", name, err)
+		}
+	}
+
+	// the key was free when checked, but only a conditional write
+	// keeps a racing upload from being replaced
+	store := s.fileRepo.CreateFile
+	if upload.Overwrite {
+		store = s.fileRepo.PutFile
+	}
+	info, err := store(ctx, key, data, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("error in PutFile: %w", err)
+	}
+	// an image the file replaced no longer turns up in similarity searches
+	s.imageService.RemoveFromSimilarityIndex(key)
+	return info, nil
+}
+
+// uploadKey returns the key asked for, which must be free unless
+// overwriting, or else one from the key template
+func (s *fileService) uploadKey(ctx context.Context, key string, upload FileUpload) (string, error) {
+	if key == "" {
+		template := s.keyTemplate
+		if template == "" {
+			template = DefaultKeyTemplate
+		}
+		return template.Generate(KeyParams{Tenant: upload.Tenant, FileName: upload.FileName}, time.Now())
+	}
+
+	if strings.HasSuffix(key, "/") {
+		return "", apperrors.NewBadRequest("key must name a file")
+	}
+	if upload.Overwrite {
+		return key, nil
+	}
+	_, err := s.fileRepo.HeadFile(ctx, key)
+	switch {
+	case err == nil:
+		return "", apperrors.NewConflict("file", key)
+	case apperrors.Status(err) == http.StatusNotFound:
+		return key, nil
+	default:
+		return "", err
+	}
+}
+
+func (s *fileService) DeleteFile(ctx context.Context, key string) error {
+	// HEAD first, since deleting a missing key succeeds
+	if _, err := s.fileRepo.HeadFile(ctx, key); err != nil {
+		return fmt.Errorf("error in DeleteFile: %w", err)
+	}
+	if err := s.fileRepo.DeleteFile(ctx, key); err != nil {
+		return fmt.Errorf("error in DeleteFile: %w", err)
+	}
+	s.imageService.RemoveFromSimilarityIndex(key)
+	return nil
+}
+
+// ListFiles lists a page of the files under prefix. The cursor is
+// the last key of the previous page
+func (s *fileService) ListFiles(ctx context.Context, prefix string, cursor string, limit int) (*model.FileList, error) {
+	files, next, err := s.fileRepo.ListFiles(ctx, prefix, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error in ListFiles: %w", err)
+	}
+	if files == nil {
+		files = []model.FileInfo{}
+	}
+	return &model.FileList{Files: files, NextCursor: next}, nil
+}
+
+// isImage reports whether a file is, or claims to be, an image. Content
+// that any registered image decoder accepts counts, whatever its type
+func isImage(data []byte, contentType string) bool {
+	if strings.HasPrefix(contentType, "image/") || imaging.IsImageContentType(imaging.DetectContentType(data)) {
+		return true
+	}
+	_, _, err := image.DecodeConfig(bytes.NewReader(data))
+	return err == nil
+}
+
+// typeAliases maps legacy MIME types to the registered ones
+var typeAliases = map[string]string{
+	"application/x-gzip": "application/gzip",
+}
+
+// fileType works out the type of a file from its content. When the content
+// doesn't tell, the declared type or else the extension is taken. A declared
+// type that contradicts the content is refused, while an extension that
+// does is ignored
+func fileType(data []byte, fileName string, declared string) (string, error) {
+	sniffed := canonicalType(http.DetectContentType(data))
+	declared = canonicalType(declared)
+	if declared == "application/octet-stream" {
+		declared = ""
+	}
+
+	switch {
+	case declared == "":
+		if byExt := canonicalType(mime.TypeByExtension(path.Ext(fileName))); byExt != "" && genericTypes[sniffed] {
+			return byExt, nil
+		}
+		return sniffed, nil
+	case genericTypes[sniffed], declared == sniffed:
+		return declared, nil
+	default:
+		return "", apperrors.NewUnsupportedMediaType(fmt.Sprintf("file declared as %s but its content is %s", declared, sniffed))
+	}
+}
+
+// canonicalType strips the parameters from a MIME type
+// and replaces legacy names
+func canonicalType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if alias, ok := typeAliases[t]; ok {
+		return alias
+	}
+	return t
+}
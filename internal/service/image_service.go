@@ -1,29 +1,95 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
 
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
 	"github.com/imkishore16/go-cloudStorage/internal/repository"
 )
 
+// names of the Deep Zoom descriptor and tile directory within a
+// pyramid, which viewers expect to sit next to each other
+const (
+	deepZoomDescriptor = "tiles.dzi"
+	deepZoomTiles      = "tiles_files/"
+)
+
 // ImageService defines the interface for image operations
 type ImageService interface {
 	GetImage(ctx context.Context, objName string) ([]byte, string, error)
-	PostImage(ctx context.Context, filePath string, objectKey string) (string, error)
-	UpdateImage(ctx context.Context, filePath string, objectKey string) (string, error)
+	PostImage(ctx context.Context, filePath string, objectKey string, contentType string, opts UploadOptions) (*model.UploadResult, error)
+	UpdateImage(ctx context.Context, filePath string, objectKey string, contentType string) (*model.UploadResult, error)
 	DeleteImage(ctx context.Context, objName string) error
+	GetImageInfo(ctx context.Context, objectKey string) (*model.ImageInfo, error)
+	SetFocalPoint(ctx context.Context, objectKey string, focus *model.FocalPoint) (*model.ImageInfo, error)
+	ListImages(ctx context.Context, opts ListOptions) (*model.ImageList, error)
+	Watermark(name string) (*imaging.Watermark, error)
+	Limits() imaging.Limits
+	TransformImage(ctx context.Context, objectKey string, opts imaging.TransformOptions) ([]byte, string, error)
+	GetVariants(ctx context.Context, objectKey string, widths []int, format string) ([]model.ImageVariant, error)
+	NegotiateImage(ctx context.Context, objectKey string, opts imaging.TransformOptions, accepted []string) ([]byte, string, error)
+	GetImageFrame(ctx context.Context, objectKey string, index int, format string) ([]byte, string, error)
+	GetDeepZoom(ctx context.Context, objectKey string, tile string) ([]byte, string, error)
+	FindSimilarImages(ctx context.Context, objectKey string, maxDistance int, limit int) ([]model.SimilarImage, error)
+	RebuildSimilarityIndex(ctx context.Context) error
+	RemoveFromSimilarityIndex(objectKey string)
+	OptimizeImages(ctx context.Context, prefix string, opts imaging.OptimizeOptions, dryRun bool) (*model.OptimizationReport, error)
+	CreateSheet(ctx context.Context, req SheetRequest) (*model.Sheet, error)
+	DiffImages(ctx context.Context, req DiffRequest) ([]byte, *model.ImageDiff, error)
+	ImportArchive(ctx context.Context, archive io.ReaderAt, size int64, prefix string, opts UploadOptions) (*model.ArchiveReport, error)
+	RunBatch(ctx context.Context, req BatchRequest) (*model.BatchResult, error)
 }
 
 // imageService is the concrete implementation of ImageService
 type imageService struct {
-	imageRepo repository.ImageRepository
+	imageRepo   repository.ImageRepository
+	uploadRules []UploadRule
+	limits      imaging.Limits
+	watermarks  map[string]*imaging.Watermark
+	index       *similarityIndex
+	keyTemplate KeyTemplate
+}
+
+// ImageServiceConfig holds the dependencies and
+// settings used to initialize an ImageService
+type ImageServiceConfig struct {
+	ImageRepository repository.ImageRepository
+	UploadRules     []UploadRule
+	Limits          imaging.Limits
+	Watermarks      map[string]*imaging.Watermark
+	KeyTemplate     KeyTemplate
+}
+
+// UploadOptions are the choices an upload makes beyond its content. An
+// upload without a key is named from the key template, with Tenant filling
+// in {tenant}, and an existing object is only replaced when Overwrite is set
+type UploadOptions struct {
+	Tenant    string
+	Overwrite bool
 }
 
 // NewImageService initializes a new ImageService
-func NewImageService(imageRepo repository.ImageRepository) ImageService {
+func NewImageService(c *ImageServiceConfig) ImageService {
 	return &imageService{
-		imageRepo: imageRepo,
+		imageRepo:   c.ImageRepository,
+		uploadRules: c.UploadRules,
+		limits:      c.Limits,
+		watermarks:  c.Watermarks,
+		index:       newSimilarityIndex(),
+		keyTemplate: c.KeyTemplate,
 	}
 }
 
@@ -36,28 +102,854 @@ func (s *imageService) GetImage(ctx context.Context, objectKey string) ([]byte,
 	return localFilePath, contentType, nil
 }
 
-// PostImage uploads a new image to the bucket
-func (s *imageService) PostImage(ctx context.Context, filePath string, objectKey string) (string, error) {
-	url, err := s.imageRepo.PostImage(ctx, filePath, objectKey)
+// TransformImage renders a resized, cropped or converted variant of an image
+func (s *imageService) TransformImage(ctx context.Context, objectKey string, opts imaging.TransformOptions) ([]byte, string, error) {
+	if err := s.checkTransform(opts); err != nil {
+		return nil, "", fmt.Errorf("error in TransformImage: %w", err)
+	}
+
+	opts = s.withFocus(ctx, objectKey, opts)
+
+	variant, contentType, err := s.renderVariant(ctx, objectKey, opts, s.imageSource(ctx, objectKey))
+	if err != nil {
+		return nil, "", fmt.Errorf("error in TransformImage: %w", err)
+	}
+	return variant, contentType, nil
+}
+
+// GetVariants renders, or fetches from the variant cache, an image at each
+// of the given widths. Widths beyond the image's own are left out, since
+// upscaling adds bytes but no detail, falling back to the original width
+func (s *imageService) GetVariants(ctx context.Context, objectKey string, widths []int, format string) ([]model.ImageVariant, error) {
+	info, err := s.GetImageInfo(ctx, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in GetVariants: %w", err)
+	}
+
+	var fitting []int
+	for _, w := range widths {
+		if w <= info.Width {
+			fitting = append(fitting, w)
+		}
+	}
+	if len(fitting) == 0 {
+		fitting = []int{info.Width}
+	}
+	sort.Ints(fitting)
+
+	source := s.imageSource(ctx, objectKey)
+	variants := make([]model.ImageVariant, 0, len(fitting))
+	for i, w := range fitting {
+		if i > 0 && w == fitting[i-1] {
+			continue
+		}
+
+		opts := imaging.TransformOptions{Width: w, Format: format}
+		if err := s.checkTransform(opts); err != nil {
+			return nil, fmt.Errorf("error in GetVariants: %w", err)
+		}
+		data, contentType, err := s.renderVariant(ctx, objectKey, opts, source)
+		if err != nil {
+			return nil, fmt.Errorf("error in GetVariants: %w", err)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error in GetVariants: failed to decode variant: %v", err)
+		}
+
+		variants = append(variants, model.ImageVariant{
+			Width:       config.Width,
+			Height:      config.Height,
+			Size:        int64(len(data)),
+			ContentType: contentType,
+		})
+	}
+	return variants, nil
+}
+
+// renderVariant returns a cached variant, rendering and caching it
+// from the image source when it is missing
+func (s *imageService) renderVariant(ctx context.Context, objectKey string, opts imaging.TransformOptions, source func() ([]byte, error)) ([]byte, string, error) {
+	name, cacheable := variantName(opts)
+	if cacheable {
+		if data, contentType, err := s.imageRepo.GetVariant(ctx, objectKey, name); err == nil {
+			return data, contentType, nil
+		}
+	}
+
+	imageData, err := source()
+	if err != nil {
+		return nil, "", err
+	}
+	variant, format, err := imaging.Transform(imageData, opts, s.limits)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cacheable {
+		if err := s.imageRepo.PutVariant(ctx, objectKey, name, variant); err != nil {
+			log.Printf("Failed to cache variant %s of %s: %v\n", name, objectKey, err)
+		}
+	}
+	return variant, format.ContentType, nil
+}
+
+// imageSource downloads an image the first time it is called and
+// returns the same bytes after that
+func (s *imageService) imageSource(ctx context.Context, objectKey string) func() ([]byte, error) {
+	var imageData []byte
+	return func() ([]byte, error) {
+		if imageData != nil {
+			return imageData, nil
+		}
+		data, _, err := s.imageRepo.GetImage(ctx, objectKey)
+		if err != nil {
+			return nil, err
+		}
+		imageData = data
+		return imageData, nil
+	}
+}
+
+// variantName names a cached variant after the options that render it.
+// Watermarked and smart cropped variants depend on more than the
+// options, so they aren't cached
+func variantName(opts imaging.TransformOptions) (string, bool) {
+	if opts.Watermark != nil || opts.Fit == imaging.FitSmart {
+		return "", false
+	}
+
+	fit := opts.Fit
+	if fit == "" {
+		fit = imaging.FitContain
+	}
+	format := opts.Format
+	if format == "" {
+		format = "auto"
+	}
+
+	name := fmt.Sprintf("%dx%d-%s", opts.Width, opts.Height, fit)
+	if !opts.Crop.Empty() {
+		name += fmt.Sprintf("-crop%d,%d,%d,%d", opts.Crop.Min.X, opts.Crop.Min.Y, opts.Crop.Dx(), opts.Crop.Dy())
+	}
+	if opts.Mirror {
+		name += "-mirror"
+	}
+	if rotate := ((opts.Rotate % 360) + 360) % 360; rotate != 0 {
+		name += fmt.Sprintf("-rot%d", rotate)
+	}
+	if opts.Quality != "" && opts.Quality != imaging.QualityColor {
+		name += "-" + string(opts.Quality)
+	}
+	return name + "." + format, true
+}
+
+// NegotiateImage serves an image, or a variant of it, converted to one of
+// the accepted formats when that is smaller than the stored format. Every
+// candidate goes through the variant cache. Animations keep their format,
+// since other outputs would drop every frame but the first
+func (s *imageService) NegotiateImage(ctx context.Context, objectKey string, opts imaging.TransformOptions, accepted []string) ([]byte, string, error) {
+	if err := s.checkTransform(opts); err != nil {
+		return nil, "", fmt.Errorf("error in NegotiateImage: %w", err)
+	}
+	opts = s.withFocus(ctx, objectKey, opts)
+
+	source := s.imageSource(ctx, objectKey)
+	var best []byte
+	var bestType string
+	var err error
+	if opts.IsZero() {
+		if best, err = source(); err == nil {
+			bestType = imaging.DetectContentType(best)
+		}
+	} else {
+		best, bestType, err = s.renderVariant(ctx, objectKey, opts, source)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error in NegotiateImage: %w", err)
+	}
+
+	// SVGs are only ever served as stored
+	if bestType == imaging.SVGContentType || s.isAnimated(ctx, objectKey, source) {
+		return best, bestType, nil
+	}
+
+	for _, format := range accepted {
+		if f, ok := imaging.FormatByName(format); !ok || f.ContentType == bestType {
+			continue
+		}
+
+		candidateOpts := opts
+		candidateOpts.Format = format
+		// a format that fails to encode this image just isn't a candidate
+		candidate, contentType, err := s.renderVariant(ctx, objectKey, candidateOpts, source)
+		if err == nil && len(candidate) < len(best) {
+			best, bestType = candidate, contentType
+		}
+	}
+	return best, bestType, nil
+}
+
+// isAnimated checks the stored info of an image for more than one
+// frame, only looking at the image itself when there is no info
+func (s *imageService) isAnimated(ctx context.Context, objectKey string, source func() ([]byte, error)) bool {
+	if info, err := s.imageRepo.GetImageInfo(ctx, objectKey); err == nil {
+		return info.FrameCount > 1
+	}
+	imageData, err := source()
+	return err == nil && imaging.IsAnimated(imageData)
+}
+
+// withFocus fills in the stored focal point of an image for smart crops.
+// Images without info or a focal point fall back to saliency
+func (s *imageService) withFocus(ctx context.Context, objectKey string, opts imaging.TransformOptions) imaging.TransformOptions {
+	if opts.Fit != imaging.FitSmart || opts.Focus != nil {
+		return opts
+	}
+	if info, err := s.imageRepo.GetImageInfo(ctx, objectKey); err == nil {
+		opts.Focus = info.FocalPoint
+	}
+	return opts
+}
+
+// GetImageFrame renders a single frame of an animated image
+func (s *imageService) GetImageFrame(ctx context.Context, objectKey string, index int, format string) ([]byte, string, error) {
+	imageData, _, err := s.imageRepo.GetImage(ctx, objectKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("error in GetImageFrame: %w", err)
+	}
+
+	frame, f, err := imaging.Frame(imageData, index, format)
+	if err != nil {
+		return nil, "", fmt.Errorf("error in GetImageFrame: %w", err)
+	}
+	return frame, f.ContentType, nil
+}
+
+// checkTransform rejects variants larger than an upload may be
+func (s *imageService) checkTransform(opts imaging.TransformOptions) error {
+	if !opts.Fit.Valid() {
+		return apperrors.NewBadRequest(fmt.Sprintf("unknown fit %q", opts.Fit))
+	}
+	if !opts.Quality.Valid() {
+		return apperrors.NewBadRequest(fmt.Sprintf("unknown quality %q", opts.Quality))
+	}
+	if opts.Rotate%90 != 0 {
+		return apperrors.NewBadRequest("rotation must be a multiple of 90 degrees")
+	}
+	if s.limits.MaxWidth > 0 && opts.Width > s.limits.MaxWidth {
+		return apperrors.NewBadRequest(fmt.Sprintf("width %d exceeds the limit of %d", opts.Width, s.limits.MaxWidth))
+	}
+	if s.limits.MaxHeight > 0 && opts.Height > s.limits.MaxHeight {
+		return apperrors.NewBadRequest(fmt.Sprintf("height %d exceeds the limit of %d", opts.Height, s.limits.MaxHeight))
+	}
+	return nil
+}
+
+// GetDeepZoom returns a file of an image's Deep Zoom pyramid: the .dzi
+// descriptor when tile is empty, or else the tile at "<level>/<column>_<row>.<ext>"
+func (s *imageService) GetDeepZoom(ctx context.Context, objectKey string, tile string) ([]byte, string, error) {
+	name := deepZoomDescriptor
+	if tile != "" {
+		name = deepZoomTiles + tile
+	}
+
+	data, contentType, err := s.imageRepo.GetTile(ctx, objectKey, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("error in GetDeepZoom: %w", err)
+	}
+	return data, contentType, nil
+}
+
+// PostImage uploads a new image to the bucket. contentType is the
+// type declared by the client and is checked against the content
+func (s *imageService) PostImage(ctx context.Context, filePath string, objectKey string, contentType string, opts UploadOptions) (*model.UploadResult, error) {
+	objectKey, err := s.uploadKey(ctx, filePath, objectKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error in PostImage: %w", err)
+	}
+
+	imageData, optimization, err := s.prepareUpload(filePath, objectKey, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("error in PostImage: %w", err)
+	}
+
+	result, err := s.storeUpload(ctx, imageData, optimization, objectKey, opts.Overwrite)
+	if err != nil {
+		return nil, fmt.Errorf("error in PostImage: %w", err)
+	}
+	return result, nil
+}
+
+// storeUpload stores a prepared upload as a new image, along with its
+// info and deep zoom tiles. Unless overwriting, the image is only stored
+// if the key is still free
+func (s *imageService) storeUpload(ctx context.Context, imageData []byte, optimization *model.Optimization, objectKey string, overwrite bool) (*model.UploadResult, error) {
+	info, duplicates, err := s.inspectUpload(imageData, objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	store := s.imageRepo.CreateImage
+	if overwrite {
+		store = s.imageRepo.PostImage
+	}
+	url, err := store(ctx, imageData, objectKey)
 	if err != nil {
-		return "", fmt.Errorf("error in PostImage: %w", err)
+		return nil, err
+	}
+
+	if err := s.storeImageInfo(ctx, info); err != nil {
+		return nil, err
 	}
-	return url, nil
+
+	deepZoom, err := s.storeDeepZoom(ctx, imageData, info)
+	if err != nil {
+		return nil, err
+	}
+	return &model.UploadResult{
+		Key:            objectKey,
+		URL:            url,
+		Info:           info,
+		NearDuplicates: duplicates,
+		Optimization:   optimization,
+		DeepZoom:       deepZoom,
+	}, nil
 }
 
 // UpdateImage updates an existing image in the bucket
-func (s *imageService) UpdateImage(ctx context.Context, filePath string, objectKey string) (string, error) {
-	url, err := s.imageRepo.UpdateImage(ctx, filePath, objectKey)
+func (s *imageService) UpdateImage(ctx context.Context, filePath string, objectKey string, contentType string) (*model.UploadResult, error) {
+	imageData, optimization, err := s.prepareUpload(filePath, objectKey, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("error in UpdateImage: %w", err)
+	}
+
+	info, duplicates, err := s.inspectUpload(imageData, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in UpdateImage: %w", err)
+	}
+
+	url, err := s.imageRepo.UpdateImage(ctx, imageData, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in UpdateImage: %w", err)
+	}
+
+	if err := s.storeImageInfo(ctx, info); err != nil {
+		return nil, fmt.Errorf("error in UpdateImage: %w", err)
+	}
+
+	deepZoom, err := s.storeDeepZoom(ctx, imageData, info)
 	if err != nil {
-		return "", fmt.Errorf("error in UpdateImage: %w", err)
+		return nil, fmt.Errorf("error in UpdateImage: %w", err)
 	}
-	return url, nil
+	return &model.UploadResult{
+		Key:            objectKey,
+		URL:            url,
+		Info:           info,
+		NearDuplicates: duplicates,
+		Optimization:   optimization,
+		DeepZoom:       deepZoom,
+	}, nil
 }
 
-// DeleteImage deletes an image from the bucket
+// DeleteImage deletes an image from the bucket, along with its info,
+// variants and tiles
 func (s *imageService) DeleteImage(ctx context.Context, objName string) error {
+	if err := checkImageKey(objName); err != nil {
+		return fmt.Errorf("error in DeleteImage: %w", err)
+	}
 	if err := s.imageRepo.DeleteImage(ctx, objName); err != nil {
 		return fmt.Errorf("error in DeleteImage: %w", err)
 	}
+	s.index.remove(objName)
+	return nil
+}
+
+// GetImageInfo returns the decoded info of a stored image. Images uploaded
+// before info was recorded are inspected once and their info persisted
+func (s *imageService) GetImageInfo(ctx context.Context, objectKey string) (*model.ImageInfo, error) {
+	info, err := s.imageRepo.GetImageInfo(ctx, objectKey)
+	if err == nil {
+		return info, nil
+	}
+
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) || appErr.Type != apperrors.NotFound {
+		return nil, fmt.Errorf("error in GetImageInfo: %w", err)
+	}
+
+	info, err = s.backfillImageInfo(ctx, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in GetImageInfo: %w", err)
+	}
+	return info, nil
+}
+
+// SetFocalPoint stores the point smart crops of an image keep in frame.
+// A nil focus clears it, so smart crops go back to the saliency heuristic
+func (s *imageService) SetFocalPoint(ctx context.Context, objectKey string, focus *model.FocalPoint) (*model.ImageInfo, error) {
+	if focus != nil && (focus.X < 0 || focus.X > 1 || focus.Y < 0 || focus.Y > 1) {
+		return nil, apperrors.NewBadRequest("focal point coordinates must be between 0 and 1")
+	}
+
+	info, err := s.GetImageInfo(ctx, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in SetFocalPoint: %w", err)
+	}
+
+	info.FocalPoint = focus
+	if err := s.imageRepo.PutImageInfo(ctx, info); err != nil {
+		return nil, fmt.Errorf("error in SetFocalPoint: %w", err)
+	}
+	return info, nil
+}
+
+// ListOptions selects a page of images. When Color is set, only images
+// with a palette color within Tolerance of it are listed
+type ListOptions struct {
+	Prefix    string
+	Cursor    string
+	Limit     int
+	Color     *color.RGBA
+	Tolerance float64
+}
+
+// ListImages returns a page of the images under prefix with their
+// placeholders. Images without recorded info are listed by key only,
+// rather than downloading each of them to fill in a listing. With a
+// color filter, pages are fetched until enough images match
+func (s *imageService) ListImages(ctx context.Context, opts ListOptions) (*model.ImageList, error) {
+	list := &model.ImageList{Images: make([]model.ImageSummary, 0, opts.Limit)}
+	cursor := opts.Cursor
+	for {
+		keys, next, err := s.imageRepo.ListImagesPage(ctx, opts.Prefix, cursor, opts.Limit-len(list.Images))
+		if err != nil {
+			return nil, fmt.Errorf("error in ListImages: %w", err)
+		}
+
+		for _, key := range keys {
+			summary := model.ImageSummary{Key: key}
+			info, err := s.imageRepo.GetImageInfo(ctx, key)
+			if err == nil {
+				summary = info.Summary()
+			} else {
+				var appErr *apperrors.Error
+				if !errors.As(err, &appErr) || appErr.Type != apperrors.NotFound {
+					return nil, fmt.Errorf("error in ListImages: %w", err)
+				}
+			}
+
+			if opts.Color != nil && !imaging.PaletteMatches(summary.Palette, *opts.Color, opts.Tolerance) {
+				continue
+			}
+			list.Images = append(list.Images, summary)
+		}
+
+		if next == "" || len(list.Images) == opts.Limit {
+			list.NextCursor = next
+			return list, nil
+		}
+		cursor = next
+	}
+}
+
+// FindSimilarImages returns the stored images whose pHash is within
+// maxDistance bits of the given image's, nearest first
+func (s *imageService) FindSimilarImages(ctx context.Context, objectKey string, maxDistance int, limit int) ([]model.SimilarImage, error) {
+	info, err := s.GetImageInfo(ctx, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in FindSimilarImages: %w", err)
+	}
+	if info.Hashes == nil {
+		if info, err = s.backfillImageInfo(ctx, objectKey); err != nil {
+			return nil, fmt.Errorf("error in FindSimilarImages: %w", err)
+		}
+	}
+
+	hash, err := imaging.ParseHash(info.Hashes.PHash)
+	if err != nil {
+		return nil, fmt.Errorf("error in FindSimilarImages: invalid pHash: %v", err)
+	}
+
+	similar := make([]model.SimilarImage, 0, limit)
+	for _, match := range s.index.search(hash, maxDistance) {
+		if match.Key == objectKey {
+			continue
+		}
+		if len(similar) == limit {
+			break
+		}
+		similar = append(similar, match)
+	}
+	return similar, nil
+}
+
+// RemoveFromSimilarityIndex drops an image from similarity searches, for
+// images that files replaced or deleted outside the image routes
+func (s *imageService) RemoveFromSimilarityIndex(objectKey string) {
+	s.index.remove(objectKey)
+}
+
+// RebuildSimilarityIndex repopulates the similarity index from the info
+// stored with every image. Images stored before hashes were recorded are
+// downloaded once to compute them
+func (s *imageService) RebuildSimilarityIndex(ctx context.Context) error {
+	// reset before listing so that images uploaded meanwhile are kept
+	s.index.reset()
+	keys, err := s.imageRepo.ListImages(ctx, "")
+	if err != nil {
+		return fmt.Errorf("error in RebuildSimilarityIndex: %w", err)
+	}
+
+	for _, key := range keys {
+		info, err := s.GetImageInfo(ctx, key)
+		if err == nil && info.Hashes == nil {
+			info, err = s.backfillImageInfo(ctx, key)
+		}
+		if err != nil {
+			log.Printf("Leaving %s out of the similarity index: %v\n", key, err)
+			continue
+		}
+		s.indexImage(info)
+	}
+
+	return nil
+}
+
+// OptimizeImages runs the optimizer over every stored image under prefix
+// and writes back the ones it shrinks, keeping their focal point and
+// upload time. Failures are reported per image and don't stop the batch
+func (s *imageService) OptimizeImages(ctx context.Context, prefix string, opts imaging.OptimizeOptions, dryRun bool) (*model.OptimizationReport, error) {
+	if !opts.Valid() {
+		return nil, apperrors.NewBadRequest("maxQuantizeError must be between 0 and 255 and jpegQuality between 0 and 100")
+	}
+
+	keys, err := s.imageRepo.ListImages(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error in OptimizeImages: %w", err)
+	}
+
+	report := &model.OptimizationReport{
+		Prefix: prefix,
+		DryRun: dryRun,
+		Images: make([]model.OptimizedImage, 0, len(keys)),
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("error in OptimizeImages: %w", err)
+		}
+
+		optimization, err := s.optimizeImage(ctx, key, opts, dryRun)
+		if err != nil {
+			report.Images = append(report.Images, model.OptimizedImage{Key: key, Error: err.Error()})
+			continue
+		}
+		report.Images = append(report.Images, model.OptimizedImage{Key: key, Optimization: optimization})
+		report.BytesSaved += optimization.BytesSaved
+	}
+	return report, nil
+}
+
+func (s *imageService) optimizeImage(ctx context.Context, key string, opts imaging.OptimizeOptions, dryRun bool) (*model.Optimization, error) {
+	imageData, _, err := s.imageRepo.GetImage(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	optimized, optimization, err := imaging.Optimize(imageData, opts)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun || optimization.BytesSaved == 0 {
+		return optimization, nil
+	}
+
+	info, err := imaging.Inspect(optimized)
+	if err != nil {
+		return nil, err
+	}
+	info.Key = key
+	info.UploadedAt = time.Now().UTC()
+	if previous, err := s.imageRepo.GetImageInfo(ctx, key); err == nil {
+		info.UploadedAt = previous.UploadedAt
+		info.FocalPoint = previous.FocalPoint
+	}
+
+	if _, err := s.imageRepo.UpdateImage(ctx, optimized, key); err != nil {
+		return nil, err
+	}
+	if err := s.storeImageInfo(ctx, info); err != nil {
+		return nil, err
+	}
+	return optimization, nil
+}
+
+// indexImage adds an image to the similarity index
+func (s *imageService) indexImage(info *model.ImageInfo) {
+	if info.Hashes == nil {
+		return
+	}
+	hash, err := imaging.ParseHash(info.Hashes.PHash)
+	if err != nil {
+		log.Printf("Leaving %s out of the similarity index: %v\n", info.Key, err)
+		return
+	}
+	s.index.add(info.Key, hash)
+}
+
+// backfillImageInfo inspects an already stored image and persists its info
+func (s *imageService) backfillImageInfo(ctx context.Context, objectKey string) (*model.ImageInfo, error) {
+	imageData, _, err := s.imageRepo.GetImage(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	// objects stored before the limits, or not through the API,
+	// could be bombs that Inspect would decode in full
+	if err := imaging.CheckLimits(imageData, s.limits); err != nil {
+		return nil, err
+	}
+
+	info, err := imaging.Inspect(imageData)
+	if err != nil {
+		return nil, err
+	}
+	info.Key = objectKey
+	info.UploadedAt = time.Now().UTC()
+
+	if err := s.imageRepo.PutImageInfo(ctx, info); err != nil {
+		return nil, err
+	}
+	s.indexImage(info)
+	return info, nil
+}
+
+// uploadKey returns the key to store an upload under: the one asked for,
+// which must be free unless overwriting, or else one from the key template
+// with the extension of the image's actual format. Either way the key
+// can't be one of the reserved prefixes sidecar data is kept under
+func (s *imageService) uploadKey(ctx context.Context, filePath string, objectKey string, opts UploadOptions) (string, error) {
+	if objectKey != "" {
+		if err := checkImageKey(objectKey); err != nil {
+			return "", err
+		}
+		return objectKey, s.checkKeyFree(ctx, objectKey, opts.Overwrite)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	template := s.keyTemplate
+	if template == "" {
+		template = DefaultKeyTemplate
+	}
+	// uuids don't collide, so the key is known to be free
+	key, err := template.Generate(KeyParams{
+		Tenant:   opts.Tenant,
+		FileName: filepath.Base(filePath),
+		Ext:      imaging.ExtensionForContentType(imaging.DetectContentType(head[:n])),
+	}, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return key, checkImageKey(key)
+}
+
+// checkImageKey refuses keys under the reserved prefixes
+func checkImageKey(objectKey string) error {
+	if repository.IsReserved(objectKey) {
+		return apperrors.NewBadRequest(fmt.Sprintf("images can't be stored under %s", objectKey))
+	}
+	return nil
+}
+
+// checkKeyFree refuses a key that is taken, unless overwriting. It only
+// saves processing an upload that would be refused, since two uploads
+// can both find a key free; storeUpload's conditional PUT settles that
+func (s *imageService) checkKeyFree(ctx context.Context, objectKey string, overwrite bool) error {
+	if overwrite {
+		return nil
+	}
+	exists, err := s.imageRepo.ImageExists(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return apperrors.NewConflict("image", objectKey)
+	}
+	return nil
+}
+
+// prepareUpload reads and validates an uploaded file and applies
+// the processing configured for the object key's prefix
+func (s *imageService) prepareUpload(filePath string, objectKey string, contentType string) ([]byte, *model.Optimization, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat file: %v", err)
+	}
+	if s.limits.MaxBytes > 0 && stat.Size() > s.limits.MaxBytes {
+		return nil, nil, apperrors.NewPayloadTooLarge(s.limits.MaxBytes, stat.Size())
+	}
+
+	imageData, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	return s.prepareImage(imageData, contentType, objectKey, filePath)
+}
+
+// prepareImage validates an upload, checking its content against the
+// declared type and file names, and applies the upload rule for its key
+func (s *imageService) prepareImage(imageData []byte, contentType string, objectKey string, fileNames ...string) ([]byte, *model.Optimization, error) {
+	sniffed, err := imaging.Validate(imageData, contentType, s.limits, append(fileNames, objectKey)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rule := s.uploadRule(objectKey)
+	if sniffed == imaging.SVGContentType {
+		return s.prepareSVG(imageData, rule)
+	}
+	processed, err := imaging.Process(imageData, imaging.ProcessOptions{
+		// the watermark has to land on the upright image
+		AutoOrient: rule.AutoOrient || rule.Watermark != "",
+		Metadata:   rule.Metadata,
+	})
+	if err != nil {
+		if rule.Watermark != "" {
+			return nil, nil, fmt.Errorf("failed to process image for watermarking: %v", err)
+		}
+		// storing it as is would keep what the rule says to strip
+		if rule.Metadata == imaging.StripAll || rule.Metadata == imaging.StripGPS {
+			return nil, nil, apperrors.NewUnsupportedMediaType(fmt.Sprintf("failed to strip image metadata: %v", err))
+		}
+		log.Printf("Storing %s unprocessed: %v\n", objectKey, err)
+		return imageData, nil, nil
+	}
+
+	if rule.Watermark != "" {
+		if processed, err = s.watermarkUpload(processed, rule.Watermark); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if rule.Optimize == nil {
+		return processed, nil, nil
+	}
+	optimized, optimization, err := imaging.Optimize(processed, *rule.Optimize)
+	if err != nil {
+		log.Printf("Storing %s unoptimized: %v\n", objectKey, err)
+		return processed, nil, nil
+	}
+	return optimized, optimization, nil
+}
+
+// prepareSVG sanitizes an SVG upload. None of the raster processing
+// applies, and rules that would burn in a watermark refuse it
+func (s *imageService) prepareSVG(data []byte, rule UploadRule) ([]byte, *model.Optimization, error) {
+	if rule.Watermark != "" {
+		return nil, nil, apperrors.NewUnsupportedMediaType("svg images can't be watermarked")
+	}
+	sanitized, err := imaging.SanitizeSVG(data)
+	if err != nil {
+		return nil, nil, apperrors.NewUnsupportedMediaType(err.Error())
+	}
+	return sanitized, nil, nil
+}
+
+// watermarkUpload burns a watermark into an upload, keeping its format.
+// Uploads in formats we can't encode are refused rather than stored
+// without the watermark
+func (s *imageService) watermarkUpload(data []byte, name string) ([]byte, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image header: %v", err)
+	}
+	if f, ok := imaging.FormatByName(format); !ok || !f.CanEncode() {
+		return nil, apperrors.NewUnsupportedMediaType(fmt.Sprintf("%s images can't be watermarked", format))
+	}
+
+	watermarked, _, err := imaging.Transform(data, imaging.TransformOptions{Watermark: s.watermarks[name]}, s.limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watermark image: %v", err)
+	}
+	return watermarked, nil
+}
+
+// Limits returns the size limits uploads and variants are held to
+func (s *imageService) Limits() imaging.Limits {
+	return s.limits
+}
+
+// Watermark returns the configured watermark with the given name
+func (s *imageService) Watermark(name string) (*imaging.Watermark, error) {
+	watermark, ok := s.watermarks[name]
+	if !ok {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("unknown watermark %q", name))
+	}
+	return watermark, nil
+}
+
+// inspectUpload computes the info of an image about to be stored and
+// applies the near-duplicate policy of the key's prefix. Images that
+// can't be decoded are stored without info
+func (s *imageService) inspectUpload(imageData []byte, objectKey string) (*model.ImageInfo, []model.SimilarImage, error) {
+	info, err := imaging.Inspect(imageData)
+	if err != nil {
+		log.Printf("Skipping image info for %s: %v\n", objectKey, err)
+		return nil, nil, nil
+	}
+	info.Key = objectKey
+	info.UploadedAt = time.Now().UTC()
+
+	duplicates, err := s.nearDuplicates(info, s.uploadRule(objectKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, duplicates, nil
+}
+
+// storeDeepZoom cuts the tile pyramid of an uploaded image when the
+// rule of its prefix asks for one. The descriptor is written last, so
+// a pyramid is only ever served once all of its tiles are in place
+func (s *imageService) storeDeepZoom(ctx context.Context, imageData []byte, info *model.ImageInfo) (*model.DeepZoom, error) {
+	if info == nil || info.ContentType == imaging.SVGContentType {
+		return nil, nil
+	}
+	opts := s.uploadRule(info.Key).DeepZoom
+	if opts == nil || !opts.Wants(info.Width, info.Height) {
+		return nil, nil
+	}
+
+	descriptor, pyramid, err := imaging.DeepZoom(imageData, *opts, func(name string, tile []byte) error {
+		return s.imageRepo.PutTile(ctx, info.Key, deepZoomTiles+name, tile)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tile pyramid: %v", err)
+	}
+	if err := s.imageRepo.PutTile(ctx, info.Key, deepZoomDescriptor, descriptor); err != nil {
+		return nil, err
+	}
+	return pyramid, nil
+}
+
+// storeImageInfo persists the info of an uploaded image next to
+// the object and adds the image to the similarity index
+func (s *imageService) storeImageInfo(ctx context.Context, info *model.ImageInfo) error {
+	if info == nil {
+		return nil
+	}
+
+	if err := s.imageRepo.PutImageInfo(ctx, info); err != nil {
+		return err
+	}
+	s.indexImage(info)
 	return nil
 }
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// DuplicatePolicy controls what happens to an upload that looks
+// like an image that is already stored
+type DuplicatePolicy string
+
+const (
+	// AllowDuplicates stores uploads without checking for near-duplicates
+	AllowDuplicates DuplicatePolicy = "allow"
+	// WarnDuplicates stores uploads and reports their near-duplicates
+	WarnDuplicates DuplicatePolicy = "warn"
+	// RejectDuplicates refuses uploads that have near-duplicates
+	RejectDuplicates DuplicatePolicy = "reject"
+)
+
+// defaultDuplicateDistance is the pHash Hamming distance under which
+// two images count as near-duplicates when a rule doesn't set one
+const defaultDuplicateDistance = 6
+
+// Valid reports whether p is one of the known policies.
+// The empty policy is treated as AllowDuplicates
+func (p DuplicatePolicy) Valid() bool {
+	switch p {
+	case "", AllowDuplicates, WarnDuplicates, RejectDuplicates:
+		return true
+	}
+	return false
+}
+
+// NearDuplicateError rejects an upload that looks like stored images.
+// It unwraps to a Conflict apperror
+type NearDuplicateError struct {
+	Err     *apperrors.Error
+	Matches []model.SimilarImage
+}
+
+func (e *NearDuplicateError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NearDuplicateError) Unwrap() error {
+	return e.Err
+}
+
+// nearDuplicates looks an upload up in the similarity index and
+// applies the rule's policy to whatever it finds
+func (s *imageService) nearDuplicates(info *model.ImageInfo, rule UploadRule) ([]model.SimilarImage, error) {
+	if rule.NearDuplicates == "" || rule.NearDuplicates == AllowDuplicates || info.Hashes == nil {
+		return nil, nil
+	}
+
+	hash, err := imaging.ParseHash(info.Hashes.PHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pHash: %v", err)
+	}
+
+	distance := rule.NearDuplicateDistance
+	if distance == 0 {
+		distance = defaultDuplicateDistance
+	}
+
+	var matches []model.SimilarImage
+	for _, match := range s.index.search(hash, distance) {
+		// replacing an image with a similar one is not a duplicate
+		if match.Key != info.Key {
+			matches = append(matches, match)
+		}
+	}
+
+	if len(matches) > 0 && rule.NearDuplicates == RejectDuplicates {
+		keys := make([]string, len(matches))
+		for i, match := range matches {
+			keys[i] = match.Key
+		}
+		return nil, &NearDuplicateError{
+			Err:     apperrors.NewConflict("near-duplicate image", strings.Join(keys, ", ")),
+			Matches: matches,
+		}
+	}
+
+	return matches, nil
+}
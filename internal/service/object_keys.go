@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+// KeyTemplate names the objects uploaded without a key. Placeholders are
+// {tenant}, {yyyy}, {mm} and {dd} for the UTC upload date, {uuid} for a
+// random UUID, {name} for the uploaded file's name without its extension
+// and {ext} for its extension, dot included
+type KeyTemplate string
+
+// DefaultKeyTemplate spreads uploads over one folder per month
+const DefaultKeyTemplate KeyTemplate = "{yyyy}/{mm}/{uuid}{ext}"
+
+var keyPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+var (
+	// tenants end up as a folder of the key, so they are kept to one segment
+	validTenant = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	// keyUnsafe matches what is left out of names and extensions put in keys
+	keyUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// KeyParams are the values a key template is filled in with
+type KeyParams struct {
+	Tenant   string
+	FileName string
+	// Ext overrides the extension of FileName, as when
+	// the content shows the file to be something else
+	Ext string
+}
+
+// Valid reports whether t uses only known placeholders and
+// includes {uuid}, without which generated keys would collide
+func (t KeyTemplate) Valid() bool {
+	for _, p := range keyPlaceholder.FindAllString(string(t), -1) {
+		switch p {
+		case "{tenant}", "{yyyy}", "{mm}", "{dd}", "{uuid}", "{name}", "{ext}":
+		default:
+			return false
+		}
+	}
+	return strings.Contains(string(t), "{uuid}") && !strings.HasPrefix(string(t), "/")
+}
+
+// UsesTenant reports whether keys made from t need a tenant
+func (t KeyTemplate) UsesTenant() bool {
+	return strings.Contains(string(t), "{tenant}")
+}
+
+// Generate fills in the template for an upload made at now
+func (t KeyTemplate) Generate(params KeyParams, now time.Time) (string, error) {
+	if t.UsesTenant() && !validTenant.MatchString(params.Tenant) {
+		return "", apperrors.NewBadRequest("a tenant of up to 64 letters, digits, - and _ is required to name the upload")
+	}
+
+	ext := params.Ext
+	if ext == "" {
+		ext = strings.ToLower(path.Ext(params.FileName))
+	}
+	ext = keyUnsafe.ReplaceAllString(ext, "")
+	if len(ext) > 16 {
+		ext = ""
+	}
+	name := strings.TrimSuffix(path.Base(params.FileName), path.Ext(params.FileName))
+	name = strings.Trim(keyUnsafe.ReplaceAllString(name, "-"), "-.")
+	if name == "" {
+		name = "file"
+	}
+
+	now = now.UTC()
+	return strings.NewReplacer(
+		"{tenant}", params.Tenant,
+		"{yyyy}", fmt.Sprintf("%04d", now.Year()),
+		"{mm}", fmt.Sprintf("%02d", now.Month()),
+		"{dd}", fmt.Sprintf("%02d", now.Day()),
+		"{uuid}", uuid.New().String(),
+		"{name}", name,
+		"{ext}", ext,
+	).Replace(string(t)), nil
+}
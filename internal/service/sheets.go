@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+	"github.com/imkishore16/go-cloudStorage/internal/model/apperrors"
+)
+
+const (
+	// maxSheetImages caps how many images go on one sheet
+	maxSheetImages = 500
+	// maxSheetCellSize bounds sheets even when no size limits are set
+	maxSheetCellSize = 1024
+)
+
+// SheetRequest asks for a sheet of either the listed Keys, in order, or
+// every image under Prefix, stored under Key. Options.Columns may be
+// zero to lay the images out in a square
+type SheetRequest struct {
+	Keys    []string
+	Prefix  string
+	Key     string
+	Options imaging.SheetOptions
+}
+
+// CreateSheet composites a set of stored images into a contact or sprite
+// sheet and stores it as a new image, never replacing one already stored
+// under its key. Images that can't be read are left out and listed as skipped
+func (s *imageService) CreateSheet(ctx context.Context, req SheetRequest) (*model.Sheet, error) {
+	if err := s.checkSheet(req); err != nil {
+		return nil, fmt.Errorf("error in CreateSheet: %w", err)
+	}
+	// fail before compositing; CreateImage still catches a racing upload
+	if err := s.checkKeyFree(ctx, req.Key, false); err != nil {
+		return nil, fmt.Errorf("error in CreateSheet: %w", err)
+	}
+
+	keys := req.Keys
+	if len(keys) == 0 {
+		var err error
+		if keys, err = s.imageRepo.ListImages(ctx, req.Prefix); err != nil {
+			return nil, fmt.Errorf("error in CreateSheet: %w", err)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("error in CreateSheet: %w", apperrors.NewBadRequest("no images to put on the sheet"))
+	}
+	if len(keys) > maxSheetImages {
+		return nil, fmt.Errorf("error in CreateSheet: %w", apperrors.NewBadRequest(fmt.Sprintf("sheets hold at most %d images, got %d", maxSheetImages, len(keys))))
+	}
+
+	opts := req.Options
+	if opts.Columns == 0 {
+		opts.Columns = int(math.Ceil(math.Sqrt(float64(len(keys)))))
+	}
+	if err := s.checkSheetSize(opts, len(keys)); err != nil {
+		return nil, fmt.Errorf("error in CreateSheet: %w", err)
+	}
+
+	images := make([]imaging.SheetImage, 0, len(keys))
+	var skipped []string
+	for _, key := range keys {
+		data, _, err := s.imageRepo.GetImage(ctx, key)
+		if err != nil {
+			skipped = append(skipped, key)
+			continue
+		}
+		images = append(images, imaging.SheetImage{Key: key, Data: data})
+	}
+
+	sheetData, _, frames, undecodable, err := imaging.Sheet(images, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error in CreateSheet: %w", err)
+	}
+	skipped = append(skipped, undecodable...)
+
+	info, _, err := s.inspectUpload(sheetData, req.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error in CreateSheet: %w", err)
+	}
+	url, err := s.imageRepo.CreateImage(ctx, sheetData, req.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error in CreateSheet: %w", err)
+	}
+	if err := s.storeImageInfo(ctx, info); err != nil {
+		return nil, fmt.Errorf("error in CreateSheet: %w", err)
+	}
+
+	width, height := opts.Size(len(frames))
+	return &model.Sheet{
+		Key:     req.Key,
+		URL:     url,
+		Layout:  string(opts.Layout),
+		Width:   width,
+		Height:  height,
+		Frames:  frames,
+		Skipped: skipped,
+	}, nil
+}
+
+func (s *imageService) checkSheet(req SheetRequest) error {
+	switch {
+	case req.Key == "":
+		return apperrors.NewBadRequest("key is required")
+	case len(req.Keys) > 0 && req.Prefix != "":
+		return apperrors.NewBadRequest("keys and prefix can't be combined")
+	case !req.Options.Layout.Valid():
+		return apperrors.NewBadRequest(fmt.Sprintf("unknown layout %q", req.Options.Layout))
+	case req.Options.CellSize <= 0, req.Options.CellSize > maxSheetCellSize:
+		return apperrors.NewBadRequest(fmt.Sprintf("cellSize must be between 1 and %d", maxSheetCellSize))
+	case req.Options.Padding < 0, req.Options.Padding > maxSheetCellSize, req.Options.Columns < 0:
+		return apperrors.NewBadRequest(fmt.Sprintf("padding must be between 0 and %d and columns can't be negative", maxSheetCellSize))
+	}
+
+	f, ok := imaging.FormatByName(req.Options.Format)
+	if !ok || !f.CanEncode() {
+		return apperrors.NewBadRequest(fmt.Sprintf("can't write %s sheets", req.Options.Format))
+	}
+	return nil
+}
+
+// checkSheetSize holds the sheet n images make to the image size limits
+func (s *imageService) checkSheetSize(opts imaging.SheetOptions, n int) error {
+	width, height := opts.Size(n)
+	if (s.limits.MaxWidth > 0 && width > s.limits.MaxWidth) ||
+		(s.limits.MaxHeight > 0 && height > s.limits.MaxHeight) ||
+		(s.limits.MaxPixels > 0 && int64(width)*int64(height) > s.limits.MaxPixels) {
+		return apperrors.NewBadRequest(fmt.Sprintf("a %dx%d sheet is larger than allowed, use fewer images or smaller cells", width, height))
+	}
+	return nil
+}
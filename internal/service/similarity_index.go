@@ -0,0 +1,118 @@
+package service
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+	"github.com/imkishore16/go-cloudStorage/internal/model"
+)
+
+// similarityIndex is an in-process BK-tree over the pHash of every
+// stored image. BK-trees don't support removal, so deleted and replaced
+// images are left in the tree and filtered out of results by checking
+// them against the current hash of their key
+type similarityIndex struct {
+	mu     sync.RWMutex
+	root   *bkNode
+	hashes map[string]uint64
+}
+
+type bkNode struct {
+	key      string
+	hash     uint64
+	children map[int]*bkNode
+}
+
+func newSimilarityIndex() *similarityIndex {
+	return &similarityIndex{
+		hashes: make(map[string]uint64),
+	}
+}
+
+// add indexes key under hash, replacing any previous hash for the key
+func (ix *similarityIndex) add(key string, hash uint64) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.hashes[key] = hash
+
+	if ix.root == nil {
+		ix.root = &bkNode{key: key, hash: hash}
+		return
+	}
+
+	node := ix.root
+	for {
+		d := imaging.HammingDistance(node.hash, hash)
+		if d == 0 && node.key == key {
+			return
+		}
+
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{key: key, hash: hash}
+			return
+		}
+		node = child
+	}
+}
+
+// remove drops key from search results
+func (ix *similarityIndex) remove(key string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	delete(ix.hashes, key)
+}
+
+// reset empties the index, dropping the tree and its stale nodes
+func (ix *similarityIndex) reset() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.root = nil
+	ix.hashes = make(map[string]uint64)
+}
+
+// search returns every indexed image within maxDistance of hash,
+// nearest first
+func (ix *similarityIndex) search(hash uint64, maxDistance int) []model.SimilarImage {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var matches []model.SimilarImage
+	if ix.root == nil {
+		return matches
+	}
+
+	stack := []*bkNode{ix.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := imaging.HammingDistance(node.hash, hash)
+		if current, ok := ix.hashes[node.key]; ok && current == node.hash && d <= maxDistance {
+			matches = append(matches, model.SimilarImage{Key: node.key, Distance: d})
+		}
+
+		// by the triangle inequality only children whose edge is within
+		// maxDistance of d can hold a match
+		for edge, child := range node.children {
+			if edge >= d-maxDistance && edge <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Key < matches[j].Key
+	})
+	return matches
+}
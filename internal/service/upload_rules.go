@@ -0,0 +1,40 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
+)
+
+// UploadRule configures the processing applied to uploads whose
+// object key starts with Prefix. The longest matching prefix wins.
+// Watermark names a configured watermark burnt into the stored image,
+// Optimize, when set, shrinks the image before it is stored, and
+// DeepZoom, when set, stores a tile pyramid for deep zoom viewers
+type UploadRule struct {
+	Prefix                string                   `json:"prefix"`
+	AutoOrient            bool                     `json:"autoOrient"`
+	Metadata              imaging.MetadataPolicy   `json:"metadata"`
+	NearDuplicates        DuplicatePolicy          `json:"nearDuplicates"`
+	NearDuplicateDistance int                      `json:"nearDuplicateDistance"`
+	Watermark             string                   `json:"watermark,omitempty"`
+	Optimize              *imaging.OptimizeOptions `json:"optimize,omitempty"`
+	DeepZoom              *imaging.DeepZoomOptions `json:"deepZoom,omitempty"`
+}
+
+// uploadRule returns the rule for an object key, or the zero
+// rule (store as uploaded) when no prefix matches
+func (s *imageService) uploadRule(objectKey string) UploadRule {
+	var match UploadRule
+	found := false
+	for _, rule := range s.uploadRules {
+		if !strings.HasPrefix(objectKey, rule.Prefix) {
+			continue
+		}
+		if !found || len(rule.Prefix) > len(match.Prefix) {
+			match = rule
+			found = true
+		}
+	}
+	return match
+}
@@ -1,14 +1,9 @@
 package utils
 
-var validImageTypes = map[string]bool{
-	"image/jpeg": true,
-	"image/png":  true,
-}
+import "github.com/imkishore16/go-cloudStorage/internal/imaging"
 
-// IsAllowedImageType determines if image is among types defined
-// in map of allowed images
+// IsAllowedImageType determines if image is among the
+// formats registered with the imaging package
 func IsAllowedImageType(mimeType string) bool {
-	_, exists := validImageTypes[mimeType]
-
-	return exists
+	return imaging.IsImageContentType(mimeType)
 }
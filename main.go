@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 	"github.com/imkishore16/go-cloudStorage/internal/handler"
+	"github.com/imkishore16/go-cloudStorage/internal/imaging"
 	"github.com/imkishore16/go-cloudStorage/internal/repository"
 	"github.com/imkishore16/go-cloudStorage/internal/service"
 )
@@ -50,23 +57,331 @@ func inject(d *dataSources) (*gin.Engine, error) {
 
 	bucketName := "mmworks-poc"
 	imageRepository := repository.NewImageRepository(d.S3Client, bucketName)
-	imageService := service.NewImageService(imageRepository)
-	imageHandler := handler.NewImageHandler(imageService)
+	watermarks, err := loadWatermarks(os.Getenv("WATERMARKS_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	uploadRules, err := loadUploadRules(os.Getenv("UPLOAD_RULES_FILE"), watermarks)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := loadLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	keyTemplate := service.DefaultKeyTemplate
+	if v := os.Getenv("KEY_TEMPLATE"); v != "" {
+		keyTemplate = service.KeyTemplate(v)
+	}
+	if !keyTemplate.Valid() {
+		return nil, fmt.Errorf("invalid key template %q", keyTemplate)
+	}
+
+	imageService := service.NewImageService(&service.ImageServiceConfig{
+		ImageRepository: imageRepository,
+		UploadRules:     uploadRules,
+		Limits:          limits,
+		Watermarks:      watermarks,
+		KeyTemplate:     keyTemplate,
+	})
+	imageHandler := handler.NewImageHandler(&handler.ImageHandlerConfig{
+		ImageService: imageService,
+		BaseURL:      os.Getenv("PUBLIC_BASE_URL"),
+		SigningKey:   []byte(os.Getenv("URL_SIGNING_KEY")),
+	})
+
+	filePolicies, err := loadFilePolicies(os.Getenv("FILE_POLICIES_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	fileService := service.NewFileService(&service.FileServiceConfig{
+		FileRepository: repository.NewFileRepository(d.S3Client, bucketName),
+		ImageService:   imageService,
+		Policies:       filePolicies,
+		MaxBytes:       limits.MaxBytes,
+		KeyTemplate:    keyTemplate,
+	})
+	fileHandler := handler.NewFileHandler(&handler.FileHandlerConfig{
+		FileService: fileService,
+	})
+
+	// Rebuilding needs the info of every stored image, so it runs in the
+	// background and similarity searches return partial results until done
+	go func() {
+		if err := imageService.RebuildSimilarityIndex(context.Background()); err != nil {
+			log.Printf("Failed to rebuild similarity index: %v\n", err)
+			return
+		}
+		log.Println("Similarity index rebuilt")
+	}()
+
+	idempotencyWindow, err := envDuration("IDEMPOTENCY_WINDOW", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	idempotencyEntries, err := envInt("IDEMPOTENCY_MAX_ENTRIES", 100_000)
+	if err != nil {
+		return nil, err
+	}
+	idempotencyBytes, err := envInt("IDEMPOTENCY_MAX_BYTES", 256<<20)
+	if err != nil {
+		return nil, err
+	}
+	idempotency := handler.NewIdempotency(&handler.IdempotencyConfig{
+		Window:     idempotencyWindow,
+		MaxEntries: int(idempotencyEntries),
+		MaxBytes:   idempotencyBytes,
+	})
+
 	router := gin.Default()
+	// match on the escaped path, so keys can hold %2F-escaped slashes
+	router.UseRawPath = true
+	router.Use(idempotency.Handle)
 
 	router.POST("/images", func(c *gin.Context) {
 		imageHandler.PostImage(c)
 	})
+	router.POST("/images/optimize", func(c *gin.Context) {
+		imageHandler.OptimizeImages(c)
+	})
+	router.POST("/images/sheets", func(c *gin.Context) {
+		imageHandler.CreateSheet(c)
+	})
+	router.POST("/images/diff", func(c *gin.Context) {
+		imageHandler.DiffImages(c)
+	})
+	router.POST("/images/archive", func(c *gin.Context) {
+		imageHandler.ImportArchive(c)
+	})
+	router.GET("/images", func(c *gin.Context) {
+		imageHandler.ListImages(c)
+	})
 	router.GET("/images/:id", func(c *gin.Context) {
 		imageHandler.GetImage(c)
 	})
-	// router.DELETE("/images/:id", func(c *gin.Context) { // Delete image by ID
-	// 	handlers.DeleteImage(c, imageService)
-	// })
+	router.DELETE("/images/:id", func(c *gin.Context) {
+		imageHandler.DeleteImage(c)
+	})
+	router.GET("/images/:id/info", func(c *gin.Context) {
+		imageHandler.GetImageInfo(c)
+	})
+	router.PUT("/images/:id/focal-point", func(c *gin.Context) {
+		imageHandler.SetFocalPoint(c)
+	})
+	router.DELETE("/images/:id/focal-point", func(c *gin.Context) {
+		imageHandler.ClearFocalPoint(c)
+	})
+	router.GET("/images/:id/frames/:frame", func(c *gin.Context) {
+		imageHandler.GetImageFrame(c)
+	})
+	router.GET("/images/:id/srcset", func(c *gin.Context) {
+		imageHandler.GetSrcset(c)
+	})
+	router.GET("/images/:id/tiles.dzi", func(c *gin.Context) {
+		imageHandler.GetDeepZoom(c)
+	})
+	router.GET("/images/:id/tiles_files/:level/:tile", func(c *gin.Context) {
+		imageHandler.GetDeepZoomTile(c)
+	})
+	router.GET("/iiif/:id", func(c *gin.Context) {
+		imageHandler.GetIIIF(c)
+	})
+	router.GET("/iiif/:id/*path", func(c *gin.Context) {
+		imageHandler.GetIIIF(c)
+	})
+	router.GET("/images/:id/similar", func(c *gin.Context) {
+		imageHandler.GetSimilarImages(c)
+	})
+	router.POST("/files", func(c *gin.Context) {
+		fileHandler.PostFile(c)
+	})
+	router.POST("/files/archive", func(c *gin.Context) {
+		fileHandler.ExportArchive(c)
+	})
+	router.GET("/files", func(c *gin.Context) {
+		fileHandler.ListFiles(c)
+	})
+	router.GET("/files/:id", func(c *gin.Context) {
+		fileHandler.GetFile(c)
+	})
+	router.GET("/files/:id/info", func(c *gin.Context) {
+		fileHandler.GetFileInfo(c)
+	})
+	router.DELETE("/files/:id", func(c *gin.Context) {
+		fileHandler.DeleteFile(c)
+	})
+	// gin can't route a colon inside a path segment, so
+	// POST /images:batch is picked out of unmatched requests
+	router.NoRoute(func(c *gin.Context) {
+		if c.Request.Method == http.MethodPost && c.Request.URL.Path == "/images:batch" {
+			imageHandler.RunBatch(c)
+		}
+	})
 
 	return router, nil
 }
 
+// loadWatermarks reads the named watermarks available to upload
+// rules and delivery-time transforms from a JSON object
+func loadWatermarks(path string) (map[string]*imaging.Watermark, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read watermarks: %w", err)
+	}
+
+	var watermarks map[string]*imaging.Watermark
+	if err := json.Unmarshal(data, &watermarks); err != nil {
+		return nil, fmt.Errorf("could not parse watermarks: %w", err)
+	}
+
+	for name, watermark := range watermarks {
+		if err := watermark.Load(); err != nil {
+			return nil, fmt.Errorf("invalid watermark %q: %w", name, err)
+		}
+	}
+
+	return watermarks, nil
+}
+
+// loadUploadRules reads the per-prefix upload processing rules
+// from a JSON file. No file means uploads are stored as sent
+func loadUploadRules(path string, watermarks map[string]*imaging.Watermark) ([]service.UploadRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read upload rules: %w", err)
+	}
+
+	var rules []service.UploadRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("could not parse upload rules: %w", err)
+	}
+
+	for _, rule := range rules {
+		if !rule.Metadata.Valid() {
+			return nil, fmt.Errorf("invalid metadata policy %q for prefix %q", rule.Metadata, rule.Prefix)
+		}
+		if !rule.NearDuplicates.Valid() {
+			return nil, fmt.Errorf("invalid near-duplicate policy %q for prefix %q", rule.NearDuplicates, rule.Prefix)
+		}
+		if rule.Optimize != nil && !rule.Optimize.Valid() {
+			return nil, fmt.Errorf("invalid optimize options for prefix %q", rule.Prefix)
+		}
+		if rule.DeepZoom != nil && !rule.DeepZoom.Valid() {
+			return nil, fmt.Errorf("invalid deep zoom options for prefix %q", rule.Prefix)
+		}
+		if _, ok := watermarks[rule.Watermark]; rule.Watermark != "" && !ok {
+			return nil, fmt.Errorf("unknown watermark %q for prefix %q", rule.Watermark, rule.Prefix)
+		}
+	}
+
+	return rules, nil
+}
+
+// loadFilePolicies reads the per-prefix policies of the file API
+// from a JSON file. No file means any file up to the size limit
+func loadFilePolicies(path string) ([]service.FilePolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file policies: %w", err)
+	}
+
+	var policies []service.FilePolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("could not parse file policies: %w", err)
+	}
+
+	for _, policy := range policies {
+		if !policy.Valid() {
+			return nil, fmt.Errorf("invalid file policy for prefix %q", policy.Prefix)
+		}
+	}
+
+	return policies, nil
+}
+
+// loadLimits reads the upload size limits from the environment
+func loadLimits() (imaging.Limits, error) {
+	maxBytes, err := envInt("MAX_BODY_BYTES", 10<<20)
+	if err != nil {
+		return imaging.Limits{}, err
+	}
+	maxWidth, err := envInt("MAX_IMAGE_WIDTH", 16384)
+	if err != nil {
+		return imaging.Limits{}, err
+	}
+	maxHeight, err := envInt("MAX_IMAGE_HEIGHT", 16384)
+	if err != nil {
+		return imaging.Limits{}, err
+	}
+	maxPixels, err := envInt("MAX_IMAGE_PIXELS", 50_000_000)
+	if err != nil {
+		return imaging.Limits{}, err
+	}
+	maxFrames, err := envInt("MAX_IMAGE_FRAMES", 1000)
+	if err != nil {
+		return imaging.Limits{}, err
+	}
+	maxAnimationPixels, err := envInt("MAX_ANIMATION_PIXELS", 400_000_000)
+	if err != nil {
+		return imaging.Limits{}, err
+	}
+
+	return imaging.Limits{
+		MaxBytes:           maxBytes,
+		MaxWidth:           int(maxWidth),
+		MaxHeight:          int(maxHeight),
+		MaxPixels:          maxPixels,
+		MaxFrames:          int(maxFrames),
+		MaxAnimationPixels: maxAnimationPixels,
+	}, nil
+}
+
+// envInt reads an integer from the environment, using
+// fallback when the variable is unset
+func envInt(name string, fallback int64) (int64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s as an integer: %w", name, err)
+	}
+	return n, nil
+}
+
+// envDuration reads a duration such as "24h" from the
+// environment, using fallback when the variable is unset
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("could not parse %s as a positive duration: %v", name, v)
+	}
+	return d, nil
+}
+
 // Main function
 func main() {
 	log.Println("Starting server...")